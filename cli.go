@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/PDOK/geopackage-optimizer-go/optimizer"
+)
+
+// Exit codes, distinguishing why a command failed
+const (
+	exitOK = 0
+	// exitFailure means the GeoPackage couldn't be optimized, didn't pass verification or differs
+	exitFailure = 1
+	// exitUsage means invalid arguments, flags or config
+	exitUsage = 2
+	// exitEnvironment means the GeoPackage, SpatiaLite or an output file isn't accessible
+	exitEnvironment = 3
+)
+
+// exitError is an error with the exit code it results in
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &exitError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+func environmentError(err error) error {
+	return &exitError{code: exitEnvironment, err: err}
+}
+
+// exitCode returns the exit code for the error a command failed with, errors of the optimizer are classified by the
+// step that failed
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	var optimizerErr *optimizer.Error
+	if errors.As(err, &optimizerErr) {
+		switch optimizerErr.Step {
+		case optimizer.StepConfig:
+			return exitUsage
+		case optimizer.StepOpen, optimizer.StepCheckRequirements, optimizer.StepWriteOutput:
+			return exitEnvironment
+		}
+	}
+	return exitFailure
+}
+
+// command is a node in the command tree, either a group of subcommands or a command that can be run
+type command struct {
+	name    string
+	args    string
+	summary string
+	// flags adds the flags of the command to the flag set, the returned function runs the command with the positional
+	// arguments once the flags are parsed
+	flags       func(flags *flag.FlagSet) func(args []string) error
+	subcommands []*command
+}
+
+func (c *command) subcommand(name string) *command {
+	for _, sub := range c.subcommands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// run runs the command, or the subcommand named by the first argument. The path holds the names of the command and
+// its parents, for the usage text.
+func (c *command) run(path []string, args []string) error {
+	if len(c.subcommands) > 0 {
+		if len(args) == 0 {
+			c.printUsage(os.Stderr, path, nil)
+			return usageErrorf("missing command")
+		}
+		if isHelp(args[0]) && c.subcommand(args[0]) == nil {
+			c.printUsage(os.Stdout, path, nil)
+			return nil
+		}
+		sub := c.subcommand(args[0])
+		if sub == nil {
+			c.printUsage(os.Stderr, path, nil)
+			return usageErrorf("unknown command '%s'", args[0])
+		}
+		return sub.run(append(path, sub.name), args[1:])
+	}
+
+	flags := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	runCommand := c.flags(flags)
+	flags.Usage = func() { c.printUsage(flags.Output(), path, flags) }
+	positional, err := parseInterspersed(flags, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	return runCommand(positional)
+}
+
+// parseInterspersed parses the flags, which unlike flag.Parse may also follow the positional arguments. The arguments
+// after "--" are positional, also when these look like flags.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, rest = args[:i], args[i+1:]
+	}
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "help"
+}
+
+func (c *command) printUsage(w io.Writer, path []string, flags *flag.FlagSet) {
+	if len(c.subcommands) > 0 {
+		fmt.Fprintf(w, "Usage: %s <command>\n\n", strings.Join(path, " "))
+		if c.summary != "" {
+			fmt.Fprintf(w, "%s\n\n", c.summary)
+		}
+		fmt.Fprintln(w, "Commands:")
+		for _, sub := range c.subcommands {
+			fmt.Fprintf(w, "  %-12s %s\n", sub.name, sub.summary)
+		}
+		fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", strings.Join(path, " "))
+		return
+	}
+	fmt.Fprintf(w, "Usage: %s [flags] %s\n\n%s\n", strings.Join(path, " "), c.args, c.summary)
+	if flags != nil && hasFlags(flags) {
+		fmt.Fprintln(w, "\nFlags:")
+		flags.SetOutput(w)
+		flags.PrintDefaults()
+	}
+}
+
+func hasFlags(flags *flag.FlagSet) bool {
+	found := false
+	flags.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
+
+// helpCommand prints the usage of the command with the given path
+func helpCommand(root func() *command) *command {
+	return &command{
+		name:    "help",
+		args:    "[command...]",
+		summary: "Print the usage of a command.",
+		flags: func(_ *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				c, path := root(), []string{programName()}
+				for _, name := range args {
+					if c = c.subcommand(name); c == nil {
+						return usageErrorf("unknown command '%s'", strings.Join(append(path[1:], name), " "))
+					}
+					path = append(path, name)
+				}
+				var flags *flag.FlagSet
+				if c.flags != nil {
+					flags = flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+					c.flags(flags)
+				}
+				c.printUsage(os.Stdout, path, flags)
+				return nil
+			}
+		},
+	}
+}
+
+// completionCommand prints a shell completion script for the command tree
+func completionCommand(root func() *command) *command {
+	return &command{
+		name:    "completion",
+		args:    "bash|zsh",
+		summary: "Print a shell completion script, e.g. 'source <(optimizer completion bash)'.",
+		flags: func(_ *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				if len(args) != 1 || (args[0] != "bash" && args[0] != "zsh") {
+					return usageErrorf("expected shell 'bash' or 'zsh'")
+				}
+				if args[0] == "zsh" {
+					fmt.Println("autoload -U +X bashcompinit && bashcompinit")
+				}
+				fmt.Print(bashCompletion(root()))
+				return nil
+			}
+		},
+	}
+}
+
+// bashCompletion completes the command names, and the flags and file names of the commands
+func bashCompletion(root *command) string {
+	name := programName()
+	function := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	var cases strings.Builder
+	var walk func(c *command, path []string)
+	walk = func(c *command, path []string) {
+		words := strings.Join(path, " ")
+		if len(c.subcommands) > 0 {
+			var names []string
+			for _, sub := range c.subcommands {
+				names = append(names, sub.name)
+				walk(sub, append(path, sub.name))
+			}
+			fmt.Fprintf(&cases, "    %q) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", words, strings.Join(names, " "))
+			return
+		}
+		flags := flag.NewFlagSet(words, flag.ContinueOnError)
+		c.flags(flags)
+		var names []string
+		flags.VisitAll(func(f *flag.Flag) { names = append(names, "-"+f.Name) })
+		sort.Strings(names)
+		fmt.Fprintf(&cases, "    %q|%q*) COMPREPLY=($(compgen -W %q -- \"$cur\")); [[ $cur == -* ]] || COMPREPLY+=($(compgen -f -- \"$cur\")) ;;\n",
+			words, words+" ", strings.Join(names, " "))
+	}
+	walk(root, nil)
+	return fmt.Sprintf(`%s() {
+  local cur="${COMP_WORDS[COMP_CWORD]}"
+  local words="${COMP_WORDS[*]:1:COMP_CWORD-1}"
+  case "$words" in
+%s  esac
+}
+complete -o filenames -F %s %s
+`, function, cases.String(), function, name)
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PDOK/geopackage-optimizer-go/optimizer"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"usage", usageErrorf("missing command"), exitUsage},
+		{"environment", environmentError(os.ErrNotExist), exitEnvironment},
+		{"wrapped exit error", fmt.Errorf("wrapped: %w", usageErrorf("unknown command")), exitUsage},
+		{"config", &optimizer.Error{Step: optimizer.StepConfig, Err: errors.New("invalid")}, exitUsage},
+		{"open", &optimizer.Error{Step: optimizer.StepOpen, Err: errors.New("no such file")}, exitEnvironment},
+		{"requirements", &optimizer.Error{Step: optimizer.StepCheckRequirements, Err: errors.New("no SpatiaLite")}, exitEnvironment},
+		{"write output", &optimizer.Error{Step: optimizer.StepWriteOutput, Err: errors.New("read-only")}, exitEnvironment},
+		{"optimization", &optimizer.Error{Step: optimizer.StepCreateIndex, Table: "layer", Err: errors.New("no such column")}, exitFailure},
+		{"other", errors.New("verification failed"), exitFailure},
+	} {
+		if code := exitCode(tc.err); code != tc.expected {
+			t.Errorf("%s: expected exit code %d, got %d", tc.name, tc.expected, code)
+		}
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	for _, tc := range []struct {
+		args       []string
+		positional []string
+		output     string
+		format     string
+		err        bool
+	}{
+		{args: []string{"a.gpkg"}, positional: []string{"a.gpkg"}, format: "text"},
+		{args: []string{"-o", "b.gpkg", "a.gpkg"}, positional: []string{"a.gpkg"}, output: "b.gpkg", format: "text"},
+		{args: []string{"a.gpkg", "-o", "b.gpkg", "-format", "json"}, positional: []string{"a.gpkg"}, output: "b.gpkg", format: "json"},
+		{args: []string{"a.gpkg", "-format=json", "c.gpkg"}, positional: []string{"a.gpkg", "c.gpkg"}, format: "json"},
+		{args: []string{"a.gpkg", "--", "-o", "b.gpkg"}, positional: []string{"a.gpkg", "-o", "b.gpkg"}, format: "text"},
+		{args: []string{"--", "-file-looking-arg", "-format", "json"}, positional: []string{"-file-looking-arg", "-format", "json"}, format: "text"},
+		{args: []string{"-o", "b.gpkg", "a.gpkg", "--", "-o", "-format=json"}, positional: []string{"a.gpkg", "-o", "-format=json"}, output: "b.gpkg", format: "text"},
+		{args: []string{}, format: "text"},
+		{args: []string{"a.gpkg", "-unknown"}, err: true},
+	} {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags.SetOutput(&nopWriter{})
+		output := flags.String("o", "", "")
+		format := flags.String("format", "text", "")
+		positional, err := parseInterspersed(flags, tc.args)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", tc.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", tc.args, err)
+			continue
+		}
+		if fmt.Sprint(positional) != fmt.Sprint(tc.positional) || *output != tc.output || *format != tc.format {
+			t.Errorf("%v: expected %v, '%s' and '%s', got %v, '%s' and '%s'", tc.args, tc.positional, tc.output, tc.format, positional, *output, *format)
+		}
+	}
+}
+
+func TestRunLegacy(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		args      func(geopackage string) []string
+		expected  int
+		optimized bool
+	}{
+		// as passed to the Docker entrypoint '/optimizer -s'
+		{"ows", func(g string) []string { return []string{"-s", g, "-service-type", "ows"} }, exitOK, true},
+		{"ows default", func(g string) []string { return []string{"-s", g} }, exitOK, true},
+		{"ows with config", func(g string) []string {
+			return []string{"-s", g, "-service-type", "ows", "-config", `{"indices":[{"name":"layer_name_idx","table":"layer","columns":["name"]}]}`}
+		}, exitOK, true},
+		{"oaf", func(g string) []string {
+			return []string{"-s", g, "-service-type", "oaf", "-config", `{"layers":{"pand":{"external-fid-columns":["identificatie"]}}}`}
+		}, exitOK, true},
+		{"dry run", func(g string) []string { return []string{"-s", g, "-service-type", "oaf", "-dry-run"} }, exitOK, false},
+		{"invalid service type", func(g string) []string { return []string{"-s", g, "-service-type", "wms"} }, exitUsage, false},
+		{"invalid config", func(g string) []string { return []string{"-s", g, "-config", "{"} }, exitUsage, false},
+		{"missing geopackage", func(g string) []string { return []string{"-s", g + ".missing"} }, exitEnvironment, false},
+	} {
+		geopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+		b, err := os.ReadFile("geopackage/original_oaf.gpkg")
+		if err != nil {
+			t.Fatalf("error reading source GeoPackage: %s", err)
+		}
+		if err = os.WriteFile(geopackage, b, 0644); err != nil {
+			t.Fatalf("error copying GeoPackage: %s", err)
+		}
+
+		if code := run(tc.args(geopackage)); code != tc.expected {
+			t.Fatalf("%s: expected exit code %d, got %d", tc.name, tc.expected, code)
+		}
+
+		db, err := optimizer.OpenDB(geopackage)
+		if err != nil {
+			t.Fatalf("error opening GeoPackage: %s", err)
+		}
+		// OWS adds puuid to every table, OAF only optimizes the configured pand table
+		var columns int
+		err = db.QueryRowContext(context.Background(), "SELECT (SELECT count(*) FROM pragma_table_info('layer') WHERE name = 'puuid') + (SELECT count(*) FROM pragma_table_info('pand') WHERE name = 'minx')").Scan(&columns)
+		db.Close()
+		if err != nil {
+			t.Fatalf("error inspecting GeoPackage: %s", err)
+		}
+		if optimized := columns > 0; optimized != tc.optimized {
+			t.Fatalf("%s: expected the GeoPackage to be optimized: %t", tc.name, tc.optimized)
+		}
+	}
+}
+
+// nopWriter discards the usage printed by the flag sets
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) { return len(p), nil }
@@ -1,260 +1,609 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/creasty/defaults"
-	"github.com/google/uuid"
-)
-
-const (
-	pdokNamespace = "098c4e26-6e36-5693-bae9-df35db0bee49"
+	"github.com/PDOK/geopackage-optimizer-go/optimizer"
+	"sigs.k8s.io/yaml"
 )
 
 func main() {
-	log.Println("Starting...")
-	sourceGeopackage := flag.String("s", "empty", "source geopackage")
-	serviceType := flag.String("service-type", "ows", "service type to optimize geopackage for")
-	config := flag.String("config", "", "optional JSON config for additional optimizations")
+	os.Exit(run(os.Args[1:]))
+}
 
-	flag.Parse()
+func run(args []string) int {
+	var err error
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+		// e.g. the Docker entrypoint '/optimizer -s'
+		err = legacy(args)
+	} else {
+		err = rootCommand().run([]string{programName()}, args)
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		return exitCode(err)
+	}
+	return exitOK
+}
 
-	switch *serviceType {
-	case "ows":
-		optimizeOWSGeopackage(*sourceGeopackage, *config)
-	case "oaf":
-		optimizeOAFGeopackage(*sourceGeopackage, *config)
-	default:
-		log.Fatalf("invalid value for service-type: '%s'", *serviceType)
+func rootCommand() *command {
+	return &command{
+		summary: "Optimizes GeoPackages for OGC webservices (ows) and OGC API Features (oaf).",
+		subcommands: []*command{
+			{
+				name:    "optimize",
+				summary: "Optimize a GeoPackage.",
+				subcommands: []*command{
+					optimizeCommand("ows"),
+					optimizeCommand("oaf"),
+				},
+			},
+			{
+				name:    "plan",
+				summary: "Print the changes an optimization would make, without making them.",
+				subcommands: []*command{
+					planCommand("ows"),
+					planCommand("oaf"),
+				},
+			},
+			{
+				name:    "verify",
+				summary: "Check that a GeoPackage was optimized.",
+				subcommands: []*command{
+					verifyCommand("ows"),
+					verifyCommand("oaf"),
+				},
+			},
+			inspectCommand(),
+			{
+				name:    "config",
+				summary: "Work with configs.",
+				subcommands: []*command{
+					{
+						name:    "init",
+						summary: "Generate a starter config from a GeoPackage.",
+						subcommands: []*command{
+							configInitCommand("ows"),
+							configInitCommand("oaf"),
+						},
+					},
+				},
+			},
+			diffCommand(),
+			revertCommand(),
+			versionCommand(),
+			completionCommand(rootCommand),
+			helpCommand(rootCommand),
+		},
 	}
 }
 
-func optimizeOAFGeopackage(sourceGeopackage string, config string) {
-	log.Printf("Performing OAF optimizations for geopackage: '%s'...\n", sourceGeopackage)
-	db := openDb(sourceGeopackage)
-	defer db.Close()
+// optimizeFlags are the flags of the optimize command, also accepted by the legacy flag form
+type optimizeFlags struct {
+	config           *string
+	output           *string
+	transactionScope *string
+	chunkSize        *int
+	singlePass       *bool
+	workers          *int
+	reportFile       *string
+}
 
-	tableNames := getTableNames(db)
+func addOptimizeFlags(flags *flag.FlagSet) *optimizeFlags {
+	f := &optimizeFlags{}
+	f.config = configFlag(flags, "optional JSON or YAML config for additional optimizations")
+	f.output = flags.String("output", "", "optional output geopackage, leaves the source geopackage untouched")
+	flags.StringVar(f.output, "o", "", "shorthand for -output")
+	f.transactionScope = flags.String("transaction-scope", string(optimizer.TransactionScopeRun), "roll back all changes ('run') or only the changes of the failing table ('table') on failure, or commit per chunk of rows ('chunk') to be able to resume an interrupted run")
+	f.chunkSize = flags.Int("chunk-size", 10000, "number of rows read, computed and written at a time")
+	f.singlePass = flags.Bool("single-pass", false, "fill the random puuid, external_fid and envelope columns with a single UPDATE instead of in chunks")
+	f.workers = flags.Int("workers", 1, "number of tables optimized concurrently, and of workers computing values within a table")
+	f.reportFile = flags.String("report", "", "optional JSON file to write a report of the optimizations performed to")
+	return f
+}
 
-	if config != "" {
-		var oafConfig OafConfig
-		err := json.Unmarshal([]byte(config), &oafConfig)
-		if err != nil {
-			log.Fatalf("cannot unmarshal oaf config: %s", err)
-		}
-		err = defaults.Set(&oafConfig)
-		if err != nil {
-			log.Fatalf("failed to set default config: %s", err)
-		}
-		for _, tableName := range tableNames {
-			if _, ok := oafConfig.Layers[tableName]; !ok {
-				log.Printf("WARNING: no config found for gpkg table '%s'", tableName)
-				continue
-			}
-			layerCfg := oafConfig.Layers[tableName]
+func configFlag(flags *flag.FlagSet, usage string) *string {
+	return flags.String("config", "", usage+": inline, '@' followed by a file path, or '-' to read from stdin")
+}
 
-			// any configured SQL statements are executed first, to allow maximum configuration freedom if needed
-			for _, stmt := range layerCfg.SQLStatements {
-				executeQuery(stmt, db)
-			}
+func (f *optimizeFlags) options() []optimizer.Option {
+	opts := []optimizer.Option{
+		optimizer.WithTransactionScope(optimizer.TransactionScope(*f.transactionScope)),
+		optimizer.WithWorkers(*f.workers),
+		optimizer.WithChunkSize(*f.chunkSize),
+	}
+	if *f.singlePass {
+		opts = append(opts, optimizer.WithSinglePass())
+	}
+	if *f.output != "" {
+		opts = append(opts, optimizer.WithOutput(*f.output))
+	}
+	return opts
+}
 
-			if layerCfg.ExternalFidColumns != nil {
-				addColumn(tableName, "external_fid", "TEXT", db)
+// commonFlags adds the logging and SpatiaLite flags, the returned function applies these once parsed
+func commonFlags(flags *flag.FlagSet) func() ([]optimizer.Option, error) {
+	setupLogging := logFlags(flags)
+	setupSpatiaLite := spatialiteFlags(flags)
+	return func() ([]optimizer.Option, error) {
+		if err := setupLogging(); err != nil {
+			return nil, err
+		}
+		return setupSpatiaLite()
+	}
+}
+
+// geopackageArg returns the single GeoPackage positional argument, which has to exist
+func geopackageArg(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", usageErrorf("expected a single geopackage, got %d arguments", len(args))
+	}
+	if _, err := os.Stat(args[0]); err != nil {
+		return "", environmentError(err)
+	}
+	return args[0], nil
+}
 
-				pdokNamespaceUUID, err := uuid.Parse(pdokNamespace)
+func optimizeCommand(serviceType string) *command {
+	return &command{
+		name:    serviceType,
+		args:    "<geopackage>",
+		summary: fmt.Sprintf("Perform the %s optimizations on the geopackage, in place unless -output is given.", strings.ToUpper(serviceType)),
+		flags: func(flags *flag.FlagSet) func(args []string) error {
+			f := addOptimizeFlags(flags)
+			setup := commonFlags(flags)
+			return func(args []string) error {
+				opts, err := setup()
 				if err != nil {
-					log.Fatalf("failed to parse PDOK namespace UUID: %v", err)
+					return err
 				}
-
-				log.Printf("Generating and setting external_fid UUIDv5 values for table '%s' based on columns: %v...", tableName, layerCfg.ExternalFidColumns)
-				tx, err := db.Begin()
+				source, err := geopackageArg(args)
 				if err != nil {
-					log.Fatalf("failed to begin transaction: %v", err)
+					return err
 				}
+				return optimize(serviceType, source, f, opts)
+			}
+		},
+	}
+}
 
-				selectCols := append([]string{layerCfg.FidColumn}, layerCfg.ExternalFidColumns...)
-				query := fmt.Sprintf("SELECT %s FROM \"%s\"", strings.Join(selectCols, ", "), tableName)
-				rows, err := tx.Query(query)
+func planCommand(serviceType string) *command {
+	return &command{
+		name:    serviceType,
+		args:    "<geopackage>",
+		summary: fmt.Sprintf("Print the changes the %s optimizations would make to the geopackage.", strings.ToUpper(serviceType)),
+		flags: func(flags *flag.FlagSet) func(args []string) error {
+			config := configFlag(flags, "optional JSON or YAML config for additional optimizations")
+			format := flags.String("format", "text", "format of the plan: 'text' or 'json'")
+			setup := commonFlags(flags)
+			return func(args []string) error {
+				if _, err := setup(); err != nil {
+					return err
+				}
+				source, err := geopackageArg(args)
 				if err != nil {
-					tx.Rollback()
-					log.Fatalf("failed to query table %s: %v", tableName, err)
+					return err
 				}
-				defer rows.Close()
+				return plan(serviceType, source, *config, *format)
+			}
+		},
+	}
+}
 
-				updateStmt, err := tx.Prepare(fmt.Sprintf("UPDATE \"%s\" SET external_fid = ? WHERE %s = ?", tableName, layerCfg.FidColumn))
+func verifyCommand(serviceType string) *command {
+	return &command{
+		name:    serviceType,
+		args:    "<geopackage>",
+		summary: fmt.Sprintf("Check that the %s optimizations were applied to the geopackage, exits with status 1 when a check failed.", strings.ToUpper(serviceType)),
+		flags: func(flags *flag.FlagSet) func(args []string) error {
+			config := configFlag(flags, "optional JSON or YAML config the geopackage was optimized with")
+			format := flags.String("format", "text", "format of the verification report: 'text' or 'json'")
+			setup := commonFlags(flags)
+			return func(args []string) error {
+				if _, err := setup(); err != nil {
+					return err
+				}
+				source, err := geopackageArg(args)
 				if err != nil {
-					tx.Rollback()
-					log.Fatalf("failed to prepare update statement for table %s: %v", tableName, err)
+					return err
 				}
-				defer updateStmt.Close()
+				return verify(serviceType, source, *config, *format)
+			}
+		},
+	}
+}
 
-				values := make([]interface{}, len(selectCols))
-				scanArgs := make([]interface{}, len(selectCols))
-				for i := range values {
-					scanArgs[i] = &values[i]
+func inspectCommand() *command {
+	return &command{
+		name:    "inspect",
+		args:    "<geopackage>",
+		summary: "Profile the tables, columns and indexes of a geopackage, suggesting columns for external-fid-columns and temporal-columns.",
+		flags: func(flags *flag.FlagSet) func(args []string) error {
+			format := flags.String("format", "text", "format of the profile: 'text' or 'json'")
+			sampleSize := flags.Int("sample-size", optimizer.DefaultSampleSize, "number of rows per table sampled for the column statistics")
+			setup := commonFlags(flags)
+			return func(args []string) error {
+				if _, err := setup(); err != nil {
+					return err
 				}
+				source, err := geopackageArg(args)
+				if err != nil {
+					return err
+				}
+				profile, err := optimizer.InspectFile(context.Background(), source, *sampleSize)
+				if err != nil {
+					return err
+				}
+				return printResult(profile, *format)
+			}
+		},
+	}
+}
 
-				rowCount := 0
-				for rows.Next() {
-					err = rows.Scan(scanArgs...)
-					if err != nil {
-						tx.Rollback()
-						log.Fatalf("failed to scan row for table %s: %v", tableName, err)
-					}
-
-					dataParts := make([]string, 0, len(values))
-					dataParts = append(dataParts, tableName)
-					for _, val := range values[1:] { // Skip the fid column (index 0)
-						if val == nil {
-							dataParts = append(dataParts, "")
-						} else {
-							dataParts = append(dataParts, fmt.Sprintf("%v", val))
-						}
+func configInitCommand(serviceType string) *command {
+	return &command{
+		name:    serviceType,
+		args:    "<geopackage>",
+		summary: fmt.Sprintf("Print a starter %s config for the geopackage, with the columns suggested by inspect filled in.", strings.ToUpper(serviceType)),
+		flags: func(flags *flag.FlagSet) func(args []string) error {
+			format := flags.String("format", "yaml", "format of the config: 'yaml' or 'json'")
+			output := flags.String("output", "", "optional file to write the config to, instead of stdout")
+			flags.StringVar(output, "o", "", "shorthand for -output")
+			sampleSize := flags.Int("sample-size", optimizer.DefaultSampleSize, "number of rows per table sampled to suggest columns")
+			setup := commonFlags(flags)
+			return func(args []string) error {
+				if _, err := setup(); err != nil {
+					return err
+				}
+				source, err := geopackageArg(args)
+				if err != nil {
+					return err
+				}
+				profile, err := optimizer.InspectFile(context.Background(), source, *sampleSize)
+				if err != nil {
+					return err
+				}
+				var config any = optimizer.InitOafConfig(profile)
+				if serviceType == "ows" {
+					config = optimizer.InitOwsConfig(profile)
+				}
+				var b []byte
+				switch *format {
+				case "yaml":
+					if b, err = yaml.Marshal(config); err != nil {
+						return fmt.Errorf("error encoding config: %w", err)
 					}
-					dataString := strings.Join(dataParts, "")
-
-					newUUID := uuid.NewSHA1(pdokNamespaceUUID, []byte(dataString))
-
-					fidValue := values[0] // Get the primary key value
-					_, err = updateStmt.Exec(newUUID.String(), fidValue)
-					if err != nil {
-						tx.Rollback()
-						log.Fatalf("failed to update row for table %s with fid %v: %v", tableName, fidValue, err)
+					header := fmt.Sprintf("# generated by '%s config init %s' from %s, review the suggested columns before use\n", programName(), serviceType, filepath.Base(source))
+					b = append([]byte(header), b...)
+				case "json":
+					if b, err = json.MarshalIndent(config, "", "  "); err != nil {
+						return fmt.Errorf("error encoding config: %w", err)
 					}
-					rowCount++
+					b = append(b, '\n')
+				default:
+					return usageErrorf("invalid value for format: '%s'", *format)
+				}
+				if *output == "" {
+					_, err = os.Stdout.Write(b)
+					return err
 				}
-				if err = rows.Err(); err != nil {
-					tx.Rollback()
-					log.Fatalf("error iterating rows for table %s: %v", tableName, err)
+				if err = os.WriteFile(*output, b, 0644); err != nil {
+					return environmentError(fmt.Errorf("error writing config: %w", err))
 				}
+				return nil
+			}
+		},
+	}
+}
 
-				err = tx.Commit()
+func diffCommand() *command {
+	return &command{
+		name:    "diff",
+		args:    "<geopackage> <geopackage>",
+		summary: "Compare the schemas of two geopackages, e.g. a delivery and its optimized version, exits with status 1 when these differ.",
+		flags: func(flags *flag.FlagSet) func(args []string) error {
+			format := flags.String("format", "text", "format of the differences: 'text' or 'json'")
+			setup := commonFlags(flags)
+			return func(args []string) error {
+				if _, err := setup(); err != nil {
+					return err
+				}
+				if len(args) != 2 {
+					return usageErrorf("expected two geopackages, got %d arguments", len(args))
+				}
+				diff, err := optimizer.DiffFiles(context.Background(), args[0], args[1])
 				if err != nil {
-					log.Fatalf("failed to commit transaction for table %s: %v", tableName, err)
+					return err
+				}
+				if err = printResult(diff, *format); err != nil {
+					return err
+				}
+				if !diff.Identical() {
+					return &exitError{code: exitFailure, err: errors.New("schemas differ")}
 				}
-				log.Printf("Finished setting external_fid values for %d rows in table '%s'.", rowCount, tableName)
+				return nil
+			}
+		},
+	}
+}
 
-				createIndex(tableName, []string{"external_fid"}, fmt.Sprintf("%s_external_fid_idx", tableName), false, db)
+func revertCommand() *command {
+	return &command{
+		name:    "revert",
+		args:    "<geopackage>",
+		summary: "Remove the columns, indexes and statistics added by the optimizer, in place unless -output is given.",
+		flags: func(flags *flag.FlagSet) func(args []string) error {
+			output := flags.String("output", "", "optional output geopackage, leaves the source geopackage untouched")
+			flags.StringVar(output, "o", "", "shorthand for -output")
+			format := flags.String("format", "text", "format of the reverted changes: 'text' or 'json'")
+			setup := commonFlags(flags)
+			return func(args []string) error {
+				opts, err := setup()
+				if err != nil {
+					return err
+				}
+				if *format != "text" && *format != "json" {
+					// checked before the geopackage is changed
+					return usageErrorf("invalid value for format: '%s'", *format)
+				}
+				source, err := geopackageArg(args)
+				if err != nil {
+					return err
+				}
+				if *output != "" {
+					opts = append(opts, optimizer.WithOutput(*output))
+				}
+				reversion, err := optimizer.RevertFile(context.Background(), source, opts...)
+				if err != nil {
+					return err
+				}
+				return printResult(reversion, *format)
 			}
+		},
+	}
+}
 
-			if layerCfg.TemporalColumns != nil {
-				createIndex(tableName, layerCfg.TemporalColumns, fmt.Sprintf("%s_temporal_idx", tableName), false, db)
+func versionCommand() *command {
+	return &command{
+		name:    "version",
+		summary: "Print the version and git commit of the optimizer, as recorded in the provenance of every run.",
+		flags: func(_ *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				if len(args) != 0 {
+					return usageErrorf("expected no arguments, got %d", len(args))
+				}
+				version, commit := optimizer.BuildVersion()
+				if commit != "" {
+					version += " (" + commit + ")"
+				}
+				fmt.Println(version)
+				return nil
 			}
+		},
+	}
+}
 
-			addOAFDefaultOptimizations(tableName, layerCfg.FidColumn, layerCfg.GeomColumn, layerCfg.TemporalColumns, db)
+// legacy runs the flag form that predates the commands, which optimizes (or with -dry-run plans) the geopackage
+// given with -s for the given -service-type
+func legacy(args []string) error {
+	flags := flag.NewFlagSet(programName(), flag.ContinueOnError)
+	sourceGeopackage := flags.String("s", "empty", "source geopackage")
+	serviceType := flags.String("service-type", "ows", "service type to optimize geopackage for")
+	dryRun := flags.Bool("dry-run", false, "print the planned changes instead of optimizing the geopackage")
+	planFormat := flags.String("plan-format", "text", "format of the plan printed with -dry-run: 'text' or 'json'")
+	f := addOptimizeFlags(flags)
+	setup := commonFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	opts, err := setup()
+	if err != nil {
+		return err
+	}
+	if *serviceType != "ows" && *serviceType != "oaf" {
+		return usageErrorf("invalid value for service-type: '%s'", *serviceType)
+	}
+	source, err := geopackageArg([]string{*sourceGeopackage})
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return plan(*serviceType, source, *f.config, *planFormat)
+	}
+	return optimize(*serviceType, source, f, opts)
+}
 
-			analyze(db)
+func optimize(serviceType string, source string, f *optimizeFlags, opts []optimizer.Option) error {
+	ctx := context.Background()
+	opts = append(f.options(), opts...)
+	var report *optimizer.Report
+	if *f.reportFile != "" {
+		report = &optimizer.Report{}
+		opts = append(opts, optimizer.WithReport(report))
+	}
+	configBytes, err := optimizer.ReadConfig(*f.config, os.Stdin)
+	if err != nil {
+		return err
+	}
+	switch serviceType {
+	case "ows":
+		var owsConfig *optimizer.OwsConfig
+		if len(configBytes) > 0 {
+			if owsConfig, err = optimizer.ParseOwsConfig(configBytes); err != nil {
+				return err
+			}
 		}
-	} else {
-		for _, tableName := range tableNames {
-			addOAFDefaultOptimizations(tableName, "fid", "geom", nil, db)
-
-			analyze(db)
+		err = optimizer.OptimizeOWSFile(ctx, source, owsConfig, opts...)
+	case "oaf":
+		var oafConfig *optimizer.OafConfig
+		if len(configBytes) > 0 {
+			if oafConfig, err = optimizer.ParseOafConfig(configBytes); err != nil {
+				return err
+			}
+		}
+		err = optimizer.OptimizeOAFFile(ctx, source, oafConfig, opts...)
+	}
+	if report != nil {
+		// also written on failure, to show how far the run got
+		if reportErr := writeReport(*f.reportFile, report); reportErr != nil && err == nil {
+			err = reportErr
 		}
 	}
+	return err
 }
 
-func addOAFDefaultOptimizations(tableName string, fidColumn string, geomColumn string, temporalColumns []string, db *sql.DB) {
-	addColumn(tableName, "minx", "numeric", db)
-	addColumn(tableName, "maxx", "numeric", db)
-	addColumn(tableName, "miny", "numeric", db)
-	addColumn(tableName, "maxy", "numeric", db)
-	setColumnValue(tableName, "minx", fmt.Sprintf("ST_MinX(%s)", geomColumn), db)
-	setColumnValue(tableName, "maxx", fmt.Sprintf("ST_MaxX(%s)", geomColumn), db)
-	setColumnValue(tableName, "miny", fmt.Sprintf("ST_MinY(%s)", geomColumn), db)
-	setColumnValue(tableName, "maxy", fmt.Sprintf("ST_MaxY(%s)", geomColumn), db)
-
-	spatialColumns := []string{fidColumn, "minx", "maxx", "miny", "maxy"}
-	if temporalColumns != nil {
-		spatialColumns = append(spatialColumns, temporalColumns...)
+func plan(serviceType string, source string, config string, format string) error {
+	ctx := context.Background()
+	configBytes, err := optimizer.ReadConfig(config, os.Stdin)
+	if err != nil {
+		return err
+	}
+	var p *optimizer.Plan
+	switch serviceType {
+	case "ows":
+		var owsConfig *optimizer.OwsConfig
+		if len(configBytes) > 0 {
+			if owsConfig, err = optimizer.ParseOwsConfig(configBytes); err != nil {
+				return err
+			}
+		}
+		p, err = optimizer.PlanOWSFile(ctx, source, owsConfig)
+	case "oaf":
+		var oafConfig *optimizer.OafConfig
+		if len(configBytes) > 0 {
+			if oafConfig, err = optimizer.ParseOafConfig(configBytes); err != nil {
+				return err
+			}
+		}
+		p, err = optimizer.PlanOAFFile(ctx, source, oafConfig)
+	}
+	if err != nil {
+		return err
 	}
-	createIndex(tableName, spatialColumns, fmt.Sprintf("%s_spatial_idx", tableName), false, db)
+	return printResult(p, format)
 }
 
-func optimizeOWSGeopackage(sourceGeopackage string, config string) {
-	log.Printf("Performing OWS optimizations for geopackage: '%s'...\n", sourceGeopackage)
-	db := openDb(sourceGeopackage)
-	defer db.Close()
-
-	tableNames := getTableNames(db)
-
-	for _, tableName := range tableNames {
-		columnName := "puuid"
-		addColumn(tableName, columnName, "TEXT", db)
-
-		log.Printf("Generating and setting puuid values for table '%s'...\n", tableName)
-		rows, err := db.Query(fmt.Sprintf("SELECT rowid FROM '%s'", tableName))
-		if err != nil {
-			log.Fatalf("error selecting rowids from '%s': %s", tableName, err)
+// verify checks an optimized geopackage and prints the outcome, fails when a check failed
+func verify(serviceType string, source string, config string, format string) error {
+	ctx := context.Background()
+	configBytes, err := optimizer.ReadConfig(config, os.Stdin)
+	if err != nil {
+		return err
+	}
+	var verification *optimizer.Verification
+	switch serviceType {
+	case "ows":
+		var owsConfig *optimizer.OwsConfig
+		if len(configBytes) > 0 {
+			if owsConfig, err = optimizer.ParseOwsConfig(configBytes); err != nil {
+				return err
+			}
 		}
-		defer rows.Close()
-
-		tx, err := db.Begin()
-		if err != nil {
-			log.Fatalf("error beginning transaction: %s", err)
+		verification, err = optimizer.VerifyOWSFile(ctx, source, owsConfig)
+	case "oaf":
+		var oafConfig *optimizer.OafConfig
+		if len(configBytes) > 0 {
+			if oafConfig, err = optimizer.ParseOafConfig(configBytes); err != nil {
+				return err
+			}
 		}
+		verification, err = optimizer.VerifyOAFFile(ctx, source, oafConfig)
+	}
+	if err != nil {
+		return err
+	}
+	if err = printResult(verification, format); err != nil {
+		return err
+	}
+	if !verification.Passed {
+		return &exitError{code: exitFailure, err: errors.New("verification failed")}
+	}
+	return nil
+}
 
-		stmt, err := tx.Prepare(fmt.Sprintf("UPDATE '%s' SET %s = ? WHERE rowid = ?", tableName, columnName))
-		if err != nil {
-			log.Fatalf("error preparing update statement for '%s': %s", tableName, err)
+// printResult prints the result of a command to stdout, as text or as JSON
+func printResult(result fmt.Stringer, format string) error {
+	switch format {
+	case "text":
+		fmt.Print(result.String())
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return fmt.Errorf("error encoding result: %w", err)
 		}
-		defer stmt.Close()
+	default:
+		return usageErrorf("invalid value for format: '%s'", format)
+	}
+	return nil
+}
 
-		var rowid int64
-		for rows.Next() {
-			if err := rows.Scan(&rowid); err != nil {
-				tx.Rollback() // Rollback on error
-				log.Fatalf("error scanning rowid: %s", err)
-			}
-			newUUID := uuid.New().String()
-			_, err = stmt.Exec(newUUID, rowid)
-			if err != nil {
-				tx.Rollback() // Rollback on error
-				log.Fatalf("error updating row %d in table '%s': %s", rowid, tableName, err)
-			}
+// logFlags adds the logging flags to the flag set, the returned function configures logging once these are parsed
+func logFlags(flags *flag.FlagSet) func() error {
+	format := flags.String("log-format", "text", "format of the log output: 'text' or 'json'")
+	level := flags.String("log-level", "info", "minimum level of the log output: 'debug', 'info', 'warn' or 'error'")
+	quiet := flags.Bool("quiet", false, "only log warnings and errors, shorthand for -log-level warn")
+	return func() error {
+		var logLevel slog.Level
+		if err := logLevel.UnmarshalText([]byte(*level)); err != nil {
+			return usageErrorf("invalid value for log-level: '%s'", *level)
 		}
-		if err = rows.Err(); err != nil { // Check for errors during iteration
-		    tx.Rollback()
-		    log.Fatalf("error iterating rows for table '%s': %s", tableName, err)
+		if *quiet {
+			logLevel = max(logLevel, slog.LevelWarn)
 		}
-
-		if err = tx.Commit(); err != nil {
-			log.Fatalf("error committing transaction for '%s': %s", tableName, err)
+		handlerOptions := &slog.HandlerOptions{Level: logLevel}
+		switch *format {
+		case "text":
+			slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, handlerOptions)))
+		case "json":
+			slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions)))
+		default:
+			return usageErrorf("invalid value for log-format: '%s'", *format)
 		}
-		log.Printf("Finished setting puuid values for table '%s'.\n", tableName)
-
-		createIndex(tableName, []string{columnName}, "", true, db)
-
-		columnName = "fuuid"
-		value := fmt.Sprintf("'%s.' || puuid", tableName)
-		addColumn(tableName, columnName, "TEXT", db)
-		setColumnValue(tableName, columnName, value, db)
-		createIndex(tableName, []string{columnName}, "", true, db)
+		return nil
 	}
+}
 
-	if config != "" {
-		var owsConfig OwsConfig
-		err := json.Unmarshal([]byte(config), &owsConfig)
+// spatialiteFlags adds the SpatiaLite flags to the flag set, the returned function configures where SpatiaLite is
+// loaded from once these are parsed. When SpatiaLite is required it fails fast if it can't be loaded, and returns
+// the option failing the run otherwise.
+func spatialiteFlags(flags *flag.FlagSet) func() ([]optimizer.Option, error) {
+	path := flags.String("spatialite-path", "", "path of the SpatiaLite extension, defaults to the "+optimizer.SpatiaLitePathEnv+" environment variable or the first well-known location found")
+	require := flags.Bool("require-spatialite", false, "fail when the SpatiaLite extension can't be loaded, instead of using the Go implemented fallbacks")
+	return func() ([]optimizer.Option, error) {
+		if *path != "" {
+			optimizer.SetSpatiaLitePath(*path)
+		}
+		if !*require {
+			return nil, nil
+		}
+		capabilities, err := optimizer.ProbeCapabilities(context.Background())
 		if err != nil {
-			log.Fatalf("cannot unmarshal ows config: %s", err)
+			return nil, err
 		}
-		if len(owsConfig.Indices) > 0 {
-			foundNames := make(map[string]bool)
-			for _, index := range owsConfig.Indices {
-				if foundNames[index.Name] {
-					log.Fatalf("Index name '%s' was found more than once", index.Name)
-				}
-				foundNames[index.Name] = true
-			}
-
-			for _, index := range owsConfig.Indices {
-				createIndex(index.Table, index.Columns, index.Name, index.Unique, db)
-			}
+		if !capabilities.SpatiaLite() {
+			return nil, environmentError(fmt.Errorf("SpatiaLite is required but couldn't be loaded: %s", capabilities.SpatiaLiteError))
 		}
+		slog.Info("SpatiaLite loaded", "version", capabilities.SpatiaLiteVersion, "path", capabilities.SpatiaLitePath)
+		return []optimizer.Option{optimizer.WithRequireSpatiaLite()}, nil
+	}
+}
+
+func writeReport(path string, report *optimizer.Report) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		return environmentError(fmt.Errorf("error writing report: %w", err))
 	}
+	return nil
 }
@@ -0,0 +1,145 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// artifactsTable records the schema objects added by the optimizer, so these can be reverted
+const artifactsTable = "pdok_optimizer_artifacts"
+
+// Kinds of artifacts recorded in the artifacts table
+const (
+	ArtifactColumn = "column"
+	ArtifactIndex  = "index"
+	ArtifactTable  = "table"
+)
+
+// Artifact is a column, index or table added by the optimizer. For tables the table is the table itself.
+type Artifact struct {
+	Kind  string `json:"kind"`
+	Table string `json:"table"`
+	Name  string `json:"name"`
+	// id is the rowid of the artifact in the artifacts table
+	id int64
+	// definition is what revert needs to restore the schema: for a column the SQL of the table before the column
+	// was added, for an index that replaced an existing index with a different definition the SQL of that index
+	definition string
+}
+
+func (a Artifact) String() string {
+	if a.Kind == ArtifactTable {
+		return fmt.Sprintf("table '%s'", a.Name)
+	}
+	return fmt.Sprintf("%s '%s' on table '%s'", a.Kind, a.Name, a.Table)
+}
+
+// recordArtifact records a schema object added by the optimizer in the GeoPackage. An artifact that's already
+// recorded keeps its original definition, so re-runs don't lose what the schema looked like before the first run.
+func recordArtifact(ctx context.Context, artifact Artifact, db dbtx) error {
+	if _, ok := db.(*planner); ok {
+		return nil
+	}
+	var definition sql.NullString
+	if artifact.definition != "" {
+		definition = sql.NullString{String: artifact.definition, Valid: true}
+	}
+	queries := []struct {
+		query string
+		args  []any
+	}{
+		{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			table_name TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			name TEXT NOT NULL,
+			definition TEXT,
+			created TEXT NOT NULL DEFAULT (strftime('%%Y-%%m-%%dT%%H:%%M:%%fZ', 'now')),
+			UNIQUE (kind, table_name, name))`, artifactsTable), nil},
+		{fmt.Sprintf("INSERT OR IGNORE INTO %s (table_name, kind, name, definition) VALUES (?, ?, ?, ?)", artifactsTable),
+			[]any{artifact.Table, artifact.Kind, artifact.Name, definition}},
+	}
+	for _, q := range queries {
+		if _, err := db.ExecContext(ctx, q.query, q.args...); err != nil {
+			return newError(StepRecordArtifact, artifact.Table, "", fmt.Errorf("error recording %s: %w", artifact, err))
+		}
+	}
+	return nil
+}
+
+// readArtifacts returns the recorded artifacts in the order these were added, or nothing when none were recorded
+func readArtifacts(ctx context.Context, db dbtx) ([]Artifact, error) {
+	exists, err := tableExists(ctx, artifactsTable, db)
+	if err != nil || !exists {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT rowid, kind, table_name, name, coalesce(definition, '') FROM %s ORDER BY rowid", artifactsTable))
+	if err != nil {
+		return nil, newError(StepInspectSchema, artifactsTable, "", err)
+	}
+	defer rows.Close()
+	var artifacts []Artifact
+	for rows.Next() {
+		var a Artifact
+		if err = rows.Scan(&a.id, &a.Kind, &a.Table, &a.Name, &a.definition); err != nil {
+			return nil, newError(StepInspectSchema, artifactsTable, "", err)
+		}
+		artifacts = append(artifacts, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, newError(StepInspectSchema, artifactsTable, "", err)
+	}
+	return artifacts, nil
+}
+
+// getSchemaSQL returns the SQL of the given table or index as found in sqlite_master, or an empty string when it
+// doesn't exist
+func getSchemaSQL(ctx context.Context, name string, db dbtx) (string, error) {
+	var definition sql.NullString
+	err := db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE lower(name) = lower(?)", name).Scan(&definition)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", newError(StepInspectSchema, "", "", fmt.Errorf("error selecting definition of '%s': %w", name, err))
+	}
+	return definition.String, nil
+}
+
+// getStatisticsTables returns the sqlite_stat tables that exist
+func getStatisticsTables(ctx context.Context, db dbtx) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'sqlite\\_stat%' ESCAPE '\\'")
+	if err != nil {
+		return nil, newError(StepInspectSchema, "", "", err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, newError(StepInspectSchema, "", "", err)
+		}
+		tables = append(tables, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, newError(StepInspectSchema, "", "", err)
+	}
+	return tables, nil
+}
+
+// recordNewTables records the given tables that didn't exist before, as listed in existing
+func recordNewTables(ctx context.Context, tables []string, existing []string, db dbtx) error {
+	for _, table := range tables {
+		found := false
+		for _, e := range existing {
+			found = found || strings.EqualFold(e, table)
+		}
+		if found {
+			continue
+		}
+		if err := recordArtifact(ctx, Artifact{Kind: ArtifactTable, Table: table, Name: table}, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
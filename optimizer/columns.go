@@ -0,0 +1,82 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// resolveColumns determines the fid and geometry column of the given table from the GeoPackage metadata: the
+// integer primary key and the geometry column registered in gpkg_geometry_columns. Configured columns are used when
+// these can't be detected, they must exist and may not conflict with the integer primary key or the registered
+// geometry column. The geometry column is only resolved for feature tables.
+func resolveColumns(ctx context.Context, table gpkgTable, fidOverride string, geomOverride string, db dbtx) (fidColumn string, geomColumn string, err error) {
+	fidColumn, err = resolveFidColumn(ctx, table, fidOverride, db)
+	if err != nil {
+		return "", "", err
+	}
+	if table.DataType != dataTypeFeatures {
+		if geomOverride != "" {
+			runOptions(db).report.warn(table.Name, "ignoring geom-column '%s' for gpkg table '%s' with data type '%s'", geomOverride, table.Name, table.DataType)
+		}
+		return fidColumn, "", nil
+	}
+	geomColumn, err = resolveGeomColumn(ctx, table, geomOverride, db)
+	if err != nil {
+		return "", "", err
+	}
+	return fidColumn, geomColumn, nil
+}
+
+func resolveFidColumn(ctx context.Context, table gpkgTable, fidOverride string, db dbtx) (string, error) {
+	detected, err := getIntegerPrimaryKeyColumn(ctx, table.Name, db)
+	if err != nil {
+		return "", err
+	}
+	if fidOverride == "" {
+		if detected == "" {
+			return "", newError(StepResolveColumns, table.Name, "", fmt.Errorf("no integer primary key found, configure a fid-column"))
+		}
+		return detected, nil
+	}
+	// while planning, sql-statements that e.g. rename the primary key aren't executed
+	if _, planning := db.(*planner); !planning && detected != "" && !strings.EqualFold(detected, fidOverride) {
+		return "", newError(StepResolveColumns, table.Name, fidOverride,
+			fmt.Errorf("configured fid-column '%s' conflicts with integer primary key '%s'", fidOverride, detected))
+	}
+	if err = checkColumnExists(ctx, table.Name, fidOverride, "fid-column", db); err != nil {
+		return "", err
+	}
+	return fidOverride, nil
+}
+
+func resolveGeomColumn(ctx context.Context, table gpkgTable, geomOverride string, db dbtx) (string, error) {
+	if geomOverride == "" {
+		if table.GeometryColumn == "" {
+			return "", newError(StepResolveColumns, table.Name, "", fmt.Errorf("no geometry column registered in gpkg_geometry_columns, configure a geom-column"))
+		}
+		return table.GeometryColumn, nil
+	}
+	if table.GeometryColumn != "" && !strings.EqualFold(table.GeometryColumn, geomOverride) {
+		return "", newError(StepResolveColumns, table.Name, geomOverride,
+			fmt.Errorf("configured geom-column '%s' conflicts with geometry column '%s' registered in gpkg_geometry_columns", geomOverride, table.GeometryColumn))
+	}
+	if err := checkColumnExists(ctx, table.Name, geomOverride, "geom-column", db); err != nil {
+		return "", err
+	}
+	return geomOverride, nil
+}
+
+func checkColumnExists(ctx context.Context, tableName string, columnName string, setting string, db dbtx) error {
+	if _, planning := db.(*planner); planning {
+		return nil // the column may be created by sql-statements, which aren't executed while planning
+	}
+	exists, err := columnExists(ctx, tableName, columnName, db)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(StepResolveColumns, tableName, columnName, fmt.Errorf("configured %s '%s' does not exist", setting, columnName))
+	}
+	return nil
+}
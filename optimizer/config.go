@@ -0,0 +1,119 @@
+package optimizer
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"sigs.k8s.io/yaml"
+)
+
+//go:generate go run ./internal/schemagen
+
+const (
+	oafConfigSchemaName = "oaf-config.schema.json"
+	owsConfigSchemaName = "ows-config.schema.json"
+)
+
+// schemas holds the published JSON Schemas, generated from OafConfig and OwsConfig
+//
+//go:embed schema/*.schema.json
+var schemas embed.FS
+
+// ReadConfig returns the config referred to by the given value, which is either an inline config,
+// '@' followed by the path to a JSON or YAML file, or '-' to read the config from stdin
+func ReadConfig(value string, stdin io.Reader) ([]byte, error) {
+	switch {
+	case value == "-":
+		config, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, newError(StepConfig, "", "", fmt.Errorf("cannot read config from stdin: %w", err))
+		}
+		return config, nil
+	case strings.HasPrefix(value, "@"):
+		config, err := os.ReadFile(value[1:])
+		if err != nil {
+			return nil, newError(StepConfig, "", "", fmt.Errorf("cannot read config file: %w", err))
+		}
+		return config, nil
+	default:
+		return []byte(value), nil
+	}
+}
+
+// decodeConfig converts the given JSON or YAML config to JSON, validates it against the given
+// JSON Schema and decodes it into target, rejecting unknown fields
+func decodeConfig(config []byte, schemaName string, target any) error {
+	jsonConfig, err := yaml.YAMLToJSON(config)
+	if err != nil {
+		return newError(StepConfig, "", "", fmt.Errorf("cannot parse config: %w", err))
+	}
+	if err = validateConfig(jsonConfig, schemaName); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonConfig))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(target); err != nil {
+		return newError(StepConfig, "", "", fmt.Errorf("cannot unmarshal config: %w", err))
+	}
+	return nil
+}
+
+func validateConfig(jsonConfig []byte, schemaName string) error {
+	schemaFile, err := schemas.Open("schema/" + schemaName)
+	if err != nil {
+		return newError(StepConfig, "", "", err)
+	}
+	defer schemaFile.Close()
+	schemaDoc, err := jsonschema.UnmarshalJSON(schemaFile)
+	if err != nil {
+		return newError(StepConfig, "", "", fmt.Errorf("invalid JSON Schema '%s': %w", schemaName, err))
+	}
+	compiler := jsonschema.NewCompiler()
+	if err = compiler.AddResource(schemaName, schemaDoc); err != nil {
+		return newError(StepConfig, "", "", err)
+	}
+	schema, err := compiler.Compile(schemaName)
+	if err != nil {
+		return newError(StepConfig, "", "", fmt.Errorf("invalid JSON Schema '%s': %w", schemaName, err))
+	}
+
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(jsonConfig))
+	if err != nil {
+		return newError(StepConfig, "", "", fmt.Errorf("cannot parse config: %w", err))
+	}
+	err = schema.Validate(instance)
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		return newError(StepConfig, "", "", fmt.Errorf("invalid config:\n%s", strings.Join(validationMessages(validationErr), "\n")))
+	} else if err != nil {
+		return newError(StepConfig, "", "", err)
+	}
+	return nil
+}
+
+// validationMessages flattens the validation error into one message per offending field,
+// e.g. "- at 'layers.pand': additional properties 'external_fid_columns' not allowed"
+func validationMessages(validationErr *jsonschema.ValidationError) []string {
+	if len(validationErr.Causes) == 0 {
+		location := strings.Join(validationErr.InstanceLocation, ".")
+		if location == "" {
+			location = "(root)"
+		}
+		printer := message.NewPrinter(language.English)
+		return []string{fmt.Sprintf("- at '%s': %s", location, validationErr.ErrorKind.LocalizedString(printer))}
+	}
+	var messages []string
+	for _, cause := range validationErr.Causes {
+		messages = append(messages, validationMessages(cause)...)
+	}
+	return messages
+}
@@ -0,0 +1,74 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InitOafConfig returns a starter OAF config for the profiled GeoPackage, with a layer for every features and
+// attributes table. The detected fid and geometry columns are filled in, the first external-fid candidate is suggested
+// as external-fid-columns and the temporal candidates as temporal-columns. Review the suggestions before use.
+func InitOafConfig(profile *Profile) *OafConfig {
+	config := &OafConfig{Layers: make(map[string]Layer)}
+	for _, table := range profile.Tables {
+		if table.DataType != dataTypeFeatures && table.DataType != dataTypeAttributes {
+			continue
+		}
+		// optional settings without a suggestion are left out of the config
+		layer := Layer{
+			FidColumn:             table.FidColumn,
+			ExternalFidAlgorithm:  ExternalFidAlgorithmCanonicalV1,
+			ExternalFidDuplicates: ExternalFidDuplicatesWarn,
+		}
+		if table.DataType == dataTypeFeatures {
+			layer.GeomColumn = table.GeometryColumn
+		}
+		for _, column := range table.Columns {
+			if column.CandidateExternalFid && len(layer.ExternalFidColumns) == 0 {
+				layer.ExternalFidColumns = append(layer.ExternalFidColumns, column.Name)
+			}
+			if column.CandidateTemporal {
+				layer.TemporalColumns = append(layer.TemporalColumns, column.Name)
+			}
+		}
+		config.Layers[table.Table] = layer
+	}
+	return config
+}
+
+// InitOwsConfig returns a starter OWS config for the profiled GeoPackage, with random puuids and indices suggested
+// for the columns features are likely looked up or filtered by: a unique index on the external-fid candidates and an
+// index on the temporal candidates. Columns that are already indexed are skipped. Review the suggestions before use.
+func InitOwsConfig(profile *Profile) *OwsConfig {
+	config := &OwsConfig{Indices: []ManualIndex{}, PUUID: &PUUIDConfig{Mode: PUUIDModeRandom}}
+	for _, table := range profile.Tables {
+		if table.DataType != dataTypeFeatures && table.DataType != dataTypeAttributes {
+			continue
+		}
+		for _, column := range table.Columns {
+			if !column.CandidateExternalFid && !column.CandidateTemporal {
+				continue
+			}
+			if indexed(table, column.Name) {
+				continue
+			}
+			config.Indices = append(config.Indices, ManualIndex{
+				Name:    fmt.Sprintf("%s_%s_idx", table.Table, strings.ToLower(column.Name)),
+				Table:   table.Table,
+				Unique:  column.CandidateExternalFid,
+				Columns: []string{column.Name},
+			})
+		}
+	}
+	return config
+}
+
+// indexed reports whether an index of the table starts with the given column, and can therefore be used to look it up
+func indexed(table TableProfile, columnName string) bool {
+	for _, index := range table.Indexes {
+		if len(index.Columns) > 0 && strings.EqualFold(index.Columns[0], columnName) {
+			return true
+		}
+	}
+	return false
+}
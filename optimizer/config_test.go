@@ -0,0 +1,171 @@
+package optimizer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseOafConfigYAML(t *testing.T) {
+	config := `
+layers:
+  pand:
+    fid-column: feature_id
+    external-fid-columns:
+      - identificatie
+`
+	oafConfig, err := ParseOafConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	layer := oafConfig.Layers["pand"]
+	if layer.FidColumn != "feature_id" || layer.GeomColumn != "" || layer.ExternalFidColumns[0] != "identificatie" {
+		t.Fatalf("unexpected layer config: %+v", layer)
+	}
+}
+
+func TestParseOafConfigUnknownField(t *testing.T) {
+	config := `{"layers":{"pand":{"external_fid_columns":["identificatie"]}}}`
+	_, err := ParseOafConfig([]byte(config))
+	if err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+	if !strings.Contains(err.Error(), "layers.pand") || !strings.Contains(err.Error(), "external_fid_columns") {
+		t.Fatalf("error doesn't point at offending layer and field: '%s'", err)
+	}
+}
+
+func TestParseOafConfigEmptyExternalFidColumns(t *testing.T) {
+	config := `{"layers":{"pand":{"external-fid-columns":[]}}}`
+	_, err := ParseOafConfig([]byte(config))
+	if err == nil || !strings.Contains(err.Error(), "external-fid-columns") {
+		t.Fatalf("expected error pointing at the empty external-fid-columns, got: '%v'", err)
+	}
+}
+
+func TestParseOwsConfigMissingColumns(t *testing.T) {
+	config := `{"indices":[{"name":"my_index","table":"mytable"}]}`
+	_, err := ParseOwsConfig([]byte(config))
+	if err == nil || !strings.Contains(err.Error(), "indices.0") {
+		t.Fatalf("expected error pointing at the first index, got: '%v'", err)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte("indices: []\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing config: %s", err)
+	}
+
+	config, err := ReadConfig("@"+configFile, nil)
+	if err != nil {
+		t.Fatalf("error reading config: %s", err)
+	}
+	if _, err = ParseOwsConfig(config); err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+
+	config, err = ReadConfig("-", strings.NewReader(`{"indices":[]}`))
+	if err != nil || string(config) != `{"indices":[]}` {
+		t.Fatalf("error reading config from stdin: '%s' %v", config, err)
+	}
+}
+
+func TestInitConfig(t *testing.T) {
+	profile, err := InspectFile(context.Background(), "../geopackage/original_oaf.gpkg", 0)
+	if err != nil {
+		t.Fatalf("error inspecting GeoPackage: %s", err)
+	}
+
+	oafJSON, err := json.Marshal(InitOafConfig(profile))
+	if err != nil {
+		t.Fatalf("error encoding config: %s", err)
+	}
+	oafConfig, err := ParseOafConfig(oafJSON)
+	if err != nil {
+		t.Fatalf("expected the generated config to be valid, got: %s", err)
+	}
+	pand := oafConfig.Layers["pand"]
+	if pand.FidColumn != "fid" || pand.GeomColumn != "geom" ||
+		!reflect.DeepEqual(pand.ExternalFidColumns, []string{"identificatie"}) || !reflect.DeepEqual(pand.TemporalColumns, []string{"bouwjaar"}) {
+		t.Fatalf("unexpected layer config for table 'pand': %+v", pand)
+	}
+	if _, ok := oafConfig.Layers["layer"]; !ok {
+		t.Fatalf("expected a layer config for every table, got: %+v", oafConfig.Layers)
+	}
+	// the generated config must be usable as is
+	if err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", oafConfig, WithOutput(filepath.Join(t.TempDir(), "oaf.gpkg"))); err != nil {
+		t.Fatalf("error optimizing GeoPackage with the generated config: %s", err)
+	}
+
+	owsJSON, err := json.Marshal(InitOwsConfig(profile))
+	if err != nil {
+		t.Fatalf("error encoding config: %s", err)
+	}
+	owsConfig, err := ParseOwsConfig(owsJSON)
+	if err != nil {
+		t.Fatalf("expected the generated config to be valid, got: %s", err)
+	}
+	found := false
+	for _, index := range owsConfig.Indices {
+		if index.Table == "pand" && index.Unique && reflect.DeepEqual(index.Columns, []string{"identificatie"}) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected a unique index on pand.identificatie, got: %+v", owsConfig.Indices)
+	}
+	if err = OptimizeOWSFile(context.Background(), "../geopackage/original_oaf.gpkg", owsConfig, WithOutput(filepath.Join(t.TempDir(), "ows.gpkg"))); err != nil {
+		t.Fatalf("error optimizing GeoPackage with the generated config: %s", err)
+	}
+}
+
+func TestInitConfigWithoutCandidates(t *testing.T) {
+	sourceGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	b, err := os.ReadFile("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error reading GeoPackage: %s", err)
+	}
+	if err = os.WriteFile(sourceGeopackage, b, 0644); err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	// without unique values there's no external-fid candidate
+	if _, err = db.Exec("UPDATE pand SET identificatie = 'x'"); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	db.Close()
+
+	profile, err := InspectFile(context.Background(), sourceGeopackage, 0)
+	if err != nil {
+		t.Fatalf("error inspecting GeoPackage: %s", err)
+	}
+	// as written by 'config init oaf'
+	oafYAML, err := yaml.Marshal(InitOafConfig(profile))
+	if err != nil {
+		t.Fatalf("error encoding config: %s", err)
+	}
+	if strings.Contains(string(oafYAML), "[]") || strings.Contains(string(oafYAML), `""`) {
+		t.Fatalf("expected no empty settings, got:\n%s", oafYAML)
+	}
+	oafConfig, err := ParseOafConfig(oafYAML)
+	if err != nil {
+		t.Fatalf("expected the generated config to be valid, got: %s", err)
+	}
+	if pand := oafConfig.Layers["pand"]; pand.ExternalFidColumns != nil {
+		t.Fatalf("expected no external-fid-columns for table 'pand', got: %v", pand.ExternalFidColumns)
+	}
+	if err = OptimizeOAFFile(context.Background(), sourceGeopackage, oafConfig); err != nil {
+		t.Fatalf("error optimizing GeoPackage with the generated config: %s", err)
+	}
+}
@@ -0,0 +1,194 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of schema objects compared by Diff
+const (
+	SchemaTable   = "table"
+	SchemaColumn  = "column"
+	SchemaIndex   = "index"
+	SchemaTrigger = "trigger"
+	SchemaView    = "view"
+)
+
+// Changes of a schema object between two GeoPackages
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// SchemaDiff lists the differences between the schemas of two GeoPackages, e.g. a delivery and its optimized version
+type SchemaDiff struct {
+	Changes []SchemaChange `json:"changes"`
+}
+
+// SchemaChange is a table, column, index, trigger or view that only exists in one of the GeoPackages, or is defined
+// differently. Before and After hold the definitions, the SQL for indexes, triggers and views and the type for columns.
+type SchemaChange struct {
+	Kind   string `json:"kind"`
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// Identical reports whether the schemas are the same
+func (d *SchemaDiff) Identical() bool {
+	return len(d.Changes) == 0
+}
+
+// String renders the differences as human-readable text
+func (d *SchemaDiff) String() string {
+	if d.Identical() {
+		return "schemas are identical\n"
+	}
+	var sb strings.Builder
+	for _, c := range d.Changes {
+		sign := map[string]string{ChangeAdded: "+", ChangeRemoved: "-", ChangeChanged: "~"}[c.Change]
+		if c.Kind == SchemaTable {
+			fmt.Fprintf(&sb, "%s table '%s'", sign, c.Name)
+		} else {
+			fmt.Fprintf(&sb, "%s %s '%s' on table '%s'", sign, c.Kind, c.Name, c.Table)
+		}
+		switch c.Change {
+		case ChangeAdded:
+			if c.Kind == SchemaColumn {
+				fmt.Fprintf(&sb, " (%s)", c.After)
+			}
+		case ChangeChanged:
+			fmt.Fprintf(&sb, ": %s -> %s", c.Before, c.After)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// DiffFiles opens the GeoPackages at the given paths and compares their schemas, without changing them
+func DiffFiles(ctx context.Context, before string, after string) (*SchemaDiff, error) {
+	beforeDB, err := openExisting(before)
+	if err != nil {
+		return nil, err
+	}
+	defer beforeDB.Close()
+	afterDB, err := openExisting(after)
+	if err != nil {
+		return nil, err
+	}
+	defer afterDB.Close()
+	return Diff(ctx, beforeDB, afterDB)
+}
+
+// Diff compares the schemas of the given GeoPackages: the tables (including the SQLite statistics tables), their
+// columns, and the indexes, triggers and views
+func Diff(ctx context.Context, before *sql.DB, after *sql.DB) (*SchemaDiff, error) {
+	beforeSchema, err := readSchema(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+	afterSchema, err := readSchema(ctx, after)
+	if err != nil {
+		return nil, err
+	}
+
+	// the columns of added and removed tables aren't listed separately
+	tableOnOneSide := func(key schemaKey) bool {
+		if key.kind != SchemaColumn {
+			return false
+		}
+		tableKey := schemaKey{SchemaTable, key.table, key.table}
+		_, inBefore := beforeSchema[tableKey]
+		_, inAfter := afterSchema[tableKey]
+		return inBefore != inAfter
+	}
+
+	diff := &SchemaDiff{Changes: []SchemaChange{}}
+	for key, b := range beforeSchema {
+		a, ok := afterSchema[key]
+		switch {
+		case tableOnOneSide(key):
+		case !ok:
+			diff.Changes = append(diff.Changes, SchemaChange{Kind: key.kind, Table: key.table, Name: key.name, Change: ChangeRemoved, Before: b})
+		case a != b:
+			diff.Changes = append(diff.Changes, SchemaChange{Kind: key.kind, Table: key.table, Name: key.name, Change: ChangeChanged, Before: b, After: a})
+		}
+	}
+	for key, a := range afterSchema {
+		if _, ok := beforeSchema[key]; !ok && !tableOnOneSide(key) {
+			diff.Changes = append(diff.Changes, SchemaChange{Kind: key.kind, Table: key.table, Name: key.name, Change: ChangeAdded, After: a})
+		}
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		ci, cj := diff.Changes[i], diff.Changes[j]
+		if ci.Table != cj.Table {
+			return ci.Table < cj.Table
+		}
+		if ci.Kind != cj.Kind {
+			// the table itself first
+			return ci.Kind == SchemaTable || (cj.Kind != SchemaTable && ci.Kind < cj.Kind)
+		}
+		return ci.Name < cj.Name
+	})
+	return diff, nil
+}
+
+type schemaKey struct {
+	kind  string
+	table string
+	name  string
+}
+
+// readSchema returns the definition of every schema object. Tables are compared by their columns, since adding a
+// column changes the SQL of a table, and tables in both GeoPackages are therefore defined by an empty string.
+func readSchema(ctx context.Context, db *sql.DB) (map[schemaKey]string, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT type, name, tbl_name, coalesce(sql, '') FROM sqlite_master WHERE name NOT LIKE 'sqlite_autoindex%'")
+	if err != nil {
+		return nil, newError(StepInspectSchema, "", "", err)
+	}
+	schema := make(map[schemaKey]string)
+	var tables []string
+	for rows.Next() {
+		var kind, name, table, definition string
+		if err = rows.Scan(&kind, &name, &table, &definition); err != nil {
+			rows.Close()
+			return nil, newError(StepInspectSchema, "", "", err)
+		}
+		if kind == SchemaTable {
+			tables = append(tables, name)
+			definition = ""
+		}
+		schema[schemaKey{kind, table, name}] = strings.Join(strings.Fields(definition), " ")
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, newError(StepInspectSchema, "", "", err)
+	}
+
+	for _, table := range tables {
+		columns, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name, type FROM pragma_table_info('%s')", strings.ReplaceAll(table, "'", "''")))
+		if err != nil {
+			return nil, newError(StepInspectSchema, table, "", err)
+		}
+		for columns.Next() {
+			var name, columnType string
+			if err = columns.Scan(&name, &columnType); err != nil {
+				columns.Close()
+				return nil, newError(StepInspectSchema, table, "", err)
+			}
+			schema[schemaKey{SchemaColumn, table, name}] = columnType
+		}
+		columns.Close()
+		if err = columns.Err(); err != nil {
+			return nil, newError(StepInspectSchema, table, "", err)
+		}
+	}
+	return schema, nil
+}
@@ -0,0 +1,51 @@
+package optimizer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffOAF(t *testing.T) {
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	if err := OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", nil, WithOutput(outputGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	diff, err := DiffFiles(context.Background(), "../geopackage/original_oaf.gpkg", "../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error comparing GeoPackages: %s", err)
+	}
+	if !diff.Identical() {
+		t.Fatalf("expected a GeoPackage to be identical to itself:\n%s", diff)
+	}
+
+	diff, err = DiffFiles(context.Background(), "../geopackage/original_oaf.gpkg", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error comparing GeoPackages: %s", err)
+	}
+	expected := []SchemaChange{
+		{Kind: SchemaColumn, Table: "pand", Name: "maxx", Change: ChangeAdded, After: "numeric"},
+		{Kind: SchemaColumn, Table: "pand", Name: "maxy", Change: ChangeAdded, After: "numeric"},
+		{Kind: SchemaColumn, Table: "pand", Name: "minx", Change: ChangeAdded, After: "numeric"},
+		{Kind: SchemaColumn, Table: "pand", Name: "miny", Change: ChangeAdded, After: "numeric"},
+		{Kind: SchemaIndex, Table: "pand", Name: "pand_spatial_idx", Change: ChangeAdded},
+		{Kind: SchemaTable, Table: "sqlite_stat1", Name: "sqlite_stat1", Change: ChangeAdded},
+	}
+	for _, e := range expected {
+		found := false
+		for _, c := range diff.Changes {
+			if c.Kind == e.Kind && c.Table == e.Table && c.Name == e.Name && c.Change == e.Change && (e.After == "" || c.After == e.After) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expected change %+v in:\n%s", e, diff)
+		}
+	}
+	for _, c := range diff.Changes {
+		if c.Change != ChangeAdded {
+			t.Fatalf("expected the optimizer to only add to the schema, got:\n%s", diff)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Step identifies the optimization step during which an error occurred
+type Step string
+
+const (
+	StepOpen                Step = "open geopackage"
+	StepCheckRequirements   Step = "check requirements"
+	StepConfig              Step = "read config"
+	StepListTables          Step = "list tables"
+	StepInspectSchema       Step = "inspect schema"
+	StepResolveColumns      Step = "resolve columns"
+	StepAddColumn           Step = "add column"
+	StepSetColumnValue      Step = "set column value"
+	StepCreateIndex         Step = "create index"
+	StepExecuteStatement    Step = "execute statement"
+	StepAnalyze             Step = "analyze"
+	StepGeneratePUUID       Step = "generate puuid"
+	StepGenerateExternalFid Step = "generate external_fid"
+	StepCreateRelation      Step = "create relation"
+	StepWriteOutput         Step = "write output"
+	StepTransaction         Step = "transaction"
+	StepVerify              Step = "verify"
+	StepRecordArtifact      Step = "record artifact"
+	StepRecordProvenance    Step = "record provenance"
+	StepRevert              Step = "revert"
+)
+
+// Error is returned by the optimizer and identifies the step, table and
+// (optionally) the column that failed
+type Error struct {
+	Step   Step
+	Table  string
+	Column string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	sb.WriteString(string(e.Step))
+	if e.Table != "" {
+		fmt.Fprintf(&sb, " (table '%s'", e.Table)
+		if e.Column != "" {
+			fmt.Fprintf(&sb, ", column '%s'", e.Column)
+		}
+		sb.WriteString(")")
+	} else if e.Column != "" {
+		fmt.Fprintf(&sb, " (column '%s')", e.Column)
+	}
+	fmt.Fprintf(&sb, ": %s", e.Err)
+	return sb.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(step Step, tableName string, columnName string, err error) error {
+	return &Error{Step: step, Table: tableName, Column: columnName, Err: err}
+}
@@ -0,0 +1,288 @@
+package optimizer
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// External fid algorithms, these determine how the values of the external-fid-columns are combined into
+// the name of the UUIDv5. An algorithm must never change once released, add a new version instead.
+const (
+	// ExternalFidAlgorithmLegacy concatenates the table name and values formatted by fmt without separator,
+	// so different values can result in the same external_fid. Kept for backwards compatibility.
+	ExternalFidAlgorithmLegacy = "legacy"
+	// ExternalFidAlgorithmCanonicalV1 joins the table name and canonicalized values with a separator
+	ExternalFidAlgorithmCanonicalV1 = "canonical-v1"
+)
+
+// Policies for rows that share an external_fid, which means the external-fid-columns aren't unique
+const (
+	// ExternalFidDuplicatesWarn logs the duplicates
+	ExternalFidDuplicatesWarn = "warn"
+	// ExternalFidDuplicatesFail fails the optimization
+	ExternalFidDuplicatesFail = "fail"
+	// ExternalFidDuplicatesUnique fails the optimization like ExternalFidDuplicatesFail, and additionally enforces
+	// unique external_fids with a UNIQUE index on external_fid, so rows changed afterwards can't introduce duplicates
+	ExternalFidDuplicatesUnique = "unique"
+	// ExternalFidDuplicatesTiebreaker appends the tiebreaker column to the values of the rows involved, fails when
+	// that doesn't resolve the duplicates and creates a UNIQUE index on external_fid
+	ExternalFidDuplicatesTiebreaker = "tiebreaker"
+)
+
+var externalFidAlgorithms = map[string]func(tableName string, values []any) string{
+	ExternalFidAlgorithmLegacy:      legacyExternalFidName,
+	ExternalFidAlgorithmCanonicalV1: canonicalExternalFidName,
+}
+
+// externalFidMetadataTable records per table how the external_fid was derived, for consumers of the GeoPackage
+const externalFidMetadataTable = "pdok_external_fid_metadata"
+
+// externalFid is a UUIDv5 in the given namespace over the table name and values, combined by the given algorithm
+func externalFid(namespace uuid.UUID, algorithm string, tableName string, values []any) string {
+	return uuid.NewSHA1(namespace, []byte(externalFidAlgorithms[algorithm](tableName, values))).String()
+}
+
+func legacyExternalFidName(tableName string, values []any) string {
+	dataParts := make([]string, 0, len(values)+1)
+	dataParts = append(dataParts, tableName)
+	for _, val := range values {
+		if val == nil {
+			dataParts = append(dataParts, "")
+		} else {
+			dataParts = append(dataParts, fmt.Sprintf("%v", val))
+		}
+	}
+	return strings.Join(dataParts, "")
+}
+
+// canonicalExternalFidName joins the table name and values with '|'. Backslashes and '|' in text are escaped with a
+// backslash, NULL is encoded as '\N', so neither is ambiguous. Numbers are formatted the shortest way that round-trips
+// (integral floats as integers), booleans as 1 or 0, dates (without time of day) as 2006-01-02, other times in UTC as
+// RFC 3339 and blobs as '\x' followed by their hex encoding.
+func canonicalExternalFidName(tableName string, values []any) string {
+	parts := make([]string, 0, len(values)+1)
+	parts = append(parts, escapeCanonical(tableName))
+	for _, val := range values {
+		parts = append(parts, canonicalValue(val))
+	}
+	return strings.Join(parts, "|")
+}
+
+func canonicalValue(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return `\N`
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		v = v.UTC()
+		if v.Equal(v.Truncate(24 * time.Hour)) {
+			return v.Format(time.DateOnly)
+		}
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return `\x` + hex.EncodeToString(v)
+	case string:
+		return escapeCanonical(v)
+	default:
+		return escapeCanonical(fmt.Sprintf("%v", v))
+	}
+}
+
+func escapeCanonical(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`).Replace(s)
+}
+
+// externalFidNamespace returns the configured namespace, or the PDOK namespace
+func externalFidNamespace(layerCfg Layer) (uuid.UUID, error) {
+	if layerCfg.ExternalFidNamespace != "" {
+		return uuid.Parse(layerCfg.ExternalFidNamespace)
+	}
+	return uuid.Parse(pdokNamespace)
+}
+
+// registerExternalFid records how the external_fid of the given table was derived
+func registerExternalFid(ctx context.Context, tableName string, layerCfg Layer, namespace uuid.UUID, db dbtx) error {
+	exists, err := tableExists(ctx, externalFidMetadataTable, db)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		table_name TEXT NOT NULL PRIMARY KEY, algorithm TEXT NOT NULL, namespace TEXT NOT NULL, columns TEXT NOT NULL)`, externalFidMetadataTable))
+	if err != nil {
+		return newError(StepGenerateExternalFid, tableName, "external_fid", fmt.Errorf("error creating %s: %w", externalFidMetadataTable, err))
+	}
+	if !exists {
+		if err = recordArtifact(ctx, Artifact{Kind: ArtifactTable, Table: externalFidMetadataTable, Name: externalFidMetadataTable}, db); err != nil {
+			return err
+		}
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO %s (table_name, algorithm, namespace, columns) VALUES (?, ?, ?, ?)", externalFidMetadataTable),
+		tableName, layerCfg.ExternalFidAlgorithm, namespace.String(), strings.Join(layerCfg.ExternalFidColumns, ","))
+	if err != nil {
+		return newError(StepGenerateExternalFid, tableName, "external_fid", fmt.Errorf("error registering external_fid: %w", err))
+	}
+	return nil
+}
+
+// DuplicateExternalFid is an external_fid shared by multiple rows of a table
+type DuplicateExternalFid struct {
+	Table       string                    `json:"table"`
+	ExternalFid string                    `json:"external_fid"`
+	Resolved    bool                      `json:"resolved"`
+	Rows        []DuplicateExternalFidRow `json:"rows"`
+}
+
+// DuplicateExternalFidRow is a row sharing an external_fid, with the values of its external-fid-columns
+type DuplicateExternalFidRow struct {
+	Fid int64 `json:"fid"`
+	Key []any `json:"key"`
+	// tiebreaker is the value of the tiebreaker column, when configured
+	tiebreaker any
+}
+
+// uniqueExternalFid reports whether the external_fid index is UNIQUE
+func uniqueExternalFid(layerCfg Layer) bool {
+	return layerCfg.ExternalFidDuplicates == ExternalFidDuplicatesUnique ||
+		layerCfg.ExternalFidDuplicates == ExternalFidDuplicatesTiebreaker
+}
+
+// checkExternalFidDuplicates finds the rows sharing an external_fid and applies the configured policy.
+// The duplicates found are returned, also when the policy fails the optimization.
+func checkExternalFidDuplicates(ctx context.Context, tableName string, layerCfg Layer, namespace uuid.UUID, db dbtx) ([]DuplicateExternalFid, error) {
+	if _, ok := db.(*planner); ok {
+		return nil, nil
+	}
+	duplicates, err := findDuplicateExternalFids(ctx, tableName, layerCfg, db)
+	if err != nil {
+		return nil, newError(StepGenerateExternalFid, tableName, "external_fid", err)
+	}
+	if len(duplicates) == 0 {
+		return nil, nil
+	}
+	rows := 0
+	for _, d := range duplicates {
+		rows += len(d.Rows)
+	}
+	duplicatesErr := fmt.Errorf("%d external_fids are shared by %d rows, external-fid-columns %v aren't unique",
+		len(duplicates), rows, layerCfg.ExternalFidColumns)
+
+	switch layerCfg.ExternalFidDuplicates {
+	case ExternalFidDuplicatesFail, ExternalFidDuplicatesUnique:
+		return duplicates, newError(StepGenerateExternalFid, tableName, "external_fid", duplicatesErr)
+	case ExternalFidDuplicatesTiebreaker:
+		slog.Info("appending tiebreaker column to duplicate external_fids", "table", tableName, "column", layerCfg.ExternalFidTiebreaker, "duplicates", len(duplicates), "rows", rows)
+		if err = applyExternalFidTiebreaker(ctx, tableName, layerCfg, namespace, duplicates, db); err != nil {
+			return duplicates, newError(StepGenerateExternalFid, tableName, "external_fid", err)
+		}
+		remaining, err := findDuplicateExternalFids(ctx, tableName, layerCfg, db)
+		if err != nil {
+			return duplicates, newError(StepGenerateExternalFid, tableName, "external_fid", err)
+		}
+		if len(remaining) > 0 {
+			return append(duplicates, remaining...), newError(StepGenerateExternalFid, tableName, "external_fid",
+				fmt.Errorf("%d external_fids are still shared after appending tiebreaker column '%s'", len(remaining), layerCfg.ExternalFidTiebreaker))
+		}
+		for i := range duplicates {
+			duplicates[i].Resolved = true
+		}
+		return duplicates, nil
+	default:
+		runOptions(db).report.warn(tableName, "%s in table '%s'", duplicatesErr, tableName)
+		return duplicates, nil
+	}
+}
+
+// findDuplicateExternalFids returns the rows sharing an external_fid, grouped by external_fid
+func findDuplicateExternalFids(ctx context.Context, tableName string, layerCfg Layer, db dbtx) ([]DuplicateExternalFid, error) {
+	columns := make([]string, 0, len(layerCfg.ExternalFidColumns)+1)
+	for _, column := range layerCfg.ExternalFidColumns {
+		columns = append(columns, quoteIdentifier(column))
+	}
+	if layerCfg.ExternalFidTiebreaker != "" {
+		columns = append(columns, quoteIdentifier(layerCfg.ExternalFidTiebreaker))
+	}
+	query := fmt.Sprintf(`SELECT %[2]s, external_fid, %[3]s FROM %[1]s WHERE external_fid IN (
+		SELECT external_fid FROM %[1]s GROUP BY external_fid HAVING count(*) > 1) ORDER BY external_fid, %[2]s`,
+		quoteIdentifier(tableName), quoteIdentifier(layerCfg.FidColumn), strings.Join(columns, ", "))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting duplicate external_fids: %w", err)
+	}
+	defer rows.Close()
+
+	var duplicates []DuplicateExternalFid
+	for rows.Next() {
+		var row DuplicateExternalFidRow
+		var fid string
+		values, scanArgs := keyScanArgs(len(columns))
+		if err = rows.Scan(append([]any{&row.Fid, &fid}, scanArgs...)...); err != nil {
+			return nil, fmt.Errorf("error scanning duplicate external_fid: %w", err)
+		}
+		row.Key = values[:len(layerCfg.ExternalFidColumns)]
+		if layerCfg.ExternalFidTiebreaker != "" {
+			row.tiebreaker = values[len(values)-1]
+		}
+		if len(duplicates) == 0 || duplicates[len(duplicates)-1].ExternalFid != fid {
+			duplicates = append(duplicates, DuplicateExternalFid{Table: tableName, ExternalFid: fid})
+		}
+		duplicates[len(duplicates)-1].Rows = append(duplicates[len(duplicates)-1].Rows, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating duplicate external_fids: %w", err)
+	}
+	return duplicates, nil
+}
+
+// applyExternalFidTiebreaker regenerates the external_fid of the duplicate rows with the tiebreaker value appended.
+// Only the rows involved change, so the external_fids of all other rows stay the same.
+func applyExternalFidTiebreaker(ctx context.Context, tableName string, layerCfg Layer, namespace uuid.UUID, duplicates []DuplicateExternalFid, db dbtx) error {
+	stmt, err := db.PrepareContext(ctx, fmt.Sprintf("UPDATE %s SET external_fid = ? WHERE %s = ?", quoteIdentifier(tableName), quoteIdentifier(layerCfg.FidColumn)))
+	if err != nil {
+		return fmt.Errorf("error preparing update statement: %w", err)
+	}
+	defer stmt.Close()
+	for _, d := range duplicates {
+		for _, row := range d.Rows {
+			fid := externalFid(namespace, layerCfg.ExternalFidAlgorithm, tableName, append(row.Key[:len(row.Key):len(row.Key)], row.tiebreaker))
+			if _, err = stmt.ExecContext(ctx, fid, row.Fid); err != nil {
+				return fmt.Errorf("error updating row %d: %w", row.Fid, err)
+			}
+		}
+	}
+	return nil
+}
+
+func writeDuplicateExternalFids(path string, duplicates []DuplicateExternalFid) error {
+	if duplicates == nil {
+		duplicates = []DuplicateExternalFid{}
+	}
+	b, err := json.MarshalIndent(duplicates, "", "  ")
+	if err != nil {
+		return newError(StepWriteOutput, "", "", fmt.Errorf("error encoding duplicate external_fids: %w", err))
+	}
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		return newError(StepWriteOutput, "", "", fmt.Errorf("error writing duplicate external_fids: %w", err))
+	}
+	return nil
+}
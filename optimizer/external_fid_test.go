@@ -0,0 +1,31 @@
+package optimizer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanonicalExternalFidName(t *testing.T) {
+	tests := []struct {
+		values   []any
+		expected string
+	}{
+		{[]any{"ab", "c"}, "pand|ab|c"},
+		{[]any{"a", "bc"}, "pand|a|bc"},
+		{[]any{nil, ""}, `pand|\N|`},
+		{[]any{`a|b\`, `\N`}, `pand|a\|b\\|\\N`},
+		{[]any{int64(1), 1.0, 1.5, 1e21}, "pand|1|1|1.5|1e+21"},
+		{[]any{true, false}, "pand|1|0"},
+		{[]any{time.Date(1951, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600))}, "pand|1951-01-01|2020-01-01T09:00:00Z"},
+		{[]any{[]byte{0xca, 0xfe}}, `pand|\xcafe`},
+	}
+	for _, test := range tests {
+		if actual := canonicalExternalFidName("pand", test.values); actual != test.expected {
+			t.Fatalf("canonical name of %v: expected '%s', got '%s'", test.values, test.expected, actual)
+		}
+	}
+
+	if legacyExternalFidName("pand", []any{"ab", "c"}) != legacyExternalFidName("pand", []any{"a", "bc"}) {
+		t.Fatal("legacy algorithm changed")
+	}
+}
@@ -0,0 +1,88 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// optimizeFile opens the given GeoPackage and calls optimize on it. When an output is configured the
+// optimizations are performed on a temporary copy of the source, which is atomically renamed to the
+// output path only when optimize succeeded.
+func optimizeFile(ctx context.Context, sourceGeopackage string, o *options, optimize func(db *sql.DB) error) error {
+	targetGeopackage := sourceGeopackage
+	if o.output != "" {
+		tempGeopackage, err := copyToTemp(ctx, sourceGeopackage, o.output)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tempGeopackage) // no-op once renamed
+		targetGeopackage = tempGeopackage
+	}
+
+	db, err := OpenDB(targetGeopackage)
+	if err != nil {
+		return err
+	}
+	if o.report != nil {
+		if o.report.Before, err = fileStats(ctx, targetGeopackage, db); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	err = optimize(db)
+	if err == nil && o.report != nil {
+		o.report.After, err = fileStats(ctx, targetGeopackage, db)
+	}
+	if closeErr := db.Close(); err == nil && closeErr != nil {
+		err = newError(StepOpen, "", "", fmt.Errorf("error closing GeoPackage: %w", closeErr))
+	}
+	if err == nil && o.report != nil {
+		// the file only has its final size once the GeoPackage is closed
+		err = o.report.After.updateSize(targetGeopackage)
+	}
+	if err != nil {
+		return err
+	}
+	if o.output == "" {
+		return nil
+	}
+
+	slog.Info("moving optimized geopackage", "output", o.output)
+	if err = os.Rename(targetGeopackage, o.output); err != nil {
+		return newError(StepWriteOutput, "", "", err)
+	}
+	return nil
+}
+
+// copyToTemp writes a consistent copy of the source GeoPackage to a temporary file next to the output
+// path (so it can be renamed atomically) and returns the path of that temporary file
+func copyToTemp(ctx context.Context, sourceGeopackage string, outputGeopackage string) (string, error) {
+	if _, err := os.Stat(sourceGeopackage); err != nil {
+		return "", newError(StepWriteOutput, "", "", err)
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(outputGeopackage), "."+filepath.Base(outputGeopackage)+".*.tmp")
+	if err != nil {
+		return "", newError(StepWriteOutput, "", "", err)
+	}
+	tempGeopackage := tempFile.Name()
+	// VACUUM INTO refuses to overwrite existing files, we only needed a unique name
+	tempFile.Close()
+	os.Remove(tempGeopackage)
+
+	slog.Info("copying geopackage", "source", sourceGeopackage, "copy", tempGeopackage)
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	if _, err = db.ExecContext(ctx, "VACUUM INTO ?", tempGeopackage); err != nil {
+		os.Remove(tempGeopackage)
+		return "", newError(StepWriteOutput, "", "", fmt.Errorf("error copying geopackage: %w", err))
+	}
+	return tempGeopackage, nil
+}
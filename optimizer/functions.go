@@ -0,0 +1,169 @@
+package optimizer
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+)
+
+// envelopeFunctions are the Go implemented SQL functions returning the bounds of a GeoPackageBinary geometry.
+// These don't depend on SpatiaLite and avoid the overhead of parsing the complete geometry when the envelope
+// is stored in the header.
+var envelopeFunctions = map[string]func(envelope) float64{
+	"pdok_minx": func(e envelope) float64 { return e.MinX },
+	"pdok_maxx": func(e envelope) float64 { return e.MaxX },
+	"pdok_miny": func(e envelope) float64 { return e.MinY },
+	"pdok_maxy": func(e envelope) float64 { return e.MaxY },
+}
+
+// spatialiteFallbacks maps the SpatiaLite functions used by GeoPackage rtree triggers (as created by GDAL)
+// to their Go implemented equivalent, these are registered when SpatiaLite isn't available
+var spatialiteFallbacks = map[string]string{
+	"ST_MinX": "pdok_minx",
+	"ST_MaxX": "pdok_maxx",
+	"ST_MinY": "pdok_miny",
+	"ST_MaxY": "pdok_maxy",
+}
+
+// registerFunctions registers the Go implemented SQL functions on the given connection
+func registerFunctions(conn *sqlite3.SQLiteConn, spatialiteLoaded bool) error {
+	for name, bound := range envelopeFunctions {
+		if err := conn.RegisterFunc(name, envelopeFunction(bound), true); err != nil {
+			return fmt.Errorf("error registering function '%s': %w", name, err)
+		}
+	}
+	for name, uuidFunction := range uuidFunctions {
+		if err := conn.RegisterFunc(name, uuidFunction.impl, uuidFunction.pure); err != nil {
+			return fmt.Errorf("error registering function '%s': %w", name, err)
+		}
+	}
+	if spatialiteLoaded {
+		return nil
+	}
+	for name, fallback := range spatialiteFallbacks {
+		if err := conn.RegisterFunc(name, envelopeFunction(envelopeFunctions[fallback]), true); err != nil {
+			return fmt.Errorf("error registering function '%s': %w", name, err)
+		}
+	}
+	if err := conn.RegisterFunc("ST_IsEmpty", isEmpty, true); err != nil {
+		return fmt.Errorf("error registering function 'ST_IsEmpty': %w", err)
+	}
+	return nil
+}
+
+// envelopeFunction returns NULL for NULL, empty and invalid geometries, like SpatiaLite does
+func envelopeFunction(bound func(envelope) float64) func(any) (any, error) {
+	return func(geom any) (any, error) {
+		env, ok := envelopeOrNull(geom)
+		if !ok {
+			return nil, nil
+		}
+		return bound(env), nil
+	}
+}
+
+// envelopeOrNull returns the envelope of a geometry column value, ok is false when the envelope is NULL. Like
+// SpatiaLite, a value that isn't a valid GeoPackageBinary geometry has a NULL envelope, a warning is logged instead
+// of failing the run.
+func envelopeOrNull(geom any) (env envelope, ok bool) {
+	env, ok, err := geometryEnvelope(geom)
+	if err != nil {
+		slog.Warn("invalid geometry, setting its envelope to NULL", "error", err)
+		return env, false
+	}
+	return env, ok
+}
+
+// geometryEnvelope returns the envelope of a GeoPackageBinary geometry column value,
+// ok is false for NULL and empty geometries
+func geometryEnvelope(geom any) (env envelope, ok bool, err error) {
+	blob, isBlob := geom.([]byte)
+	if geom == nil || (isBlob && len(blob) == 0) {
+		return env, false, nil
+	}
+	if !isBlob {
+		return env, false, fmt.Errorf("expected a GeoPackageBinary geometry, got: %T", geom)
+	}
+	env, err = parseEnvelope(blob)
+	if err != nil {
+		return env, false, err
+	}
+	return env, !env.Empty, nil
+}
+
+func isEmpty(geom any) (any, error) {
+	blob, ok := geom.([]byte)
+	if geom == nil || (ok && len(blob) == 0) {
+		return nil, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("expected a GeoPackageBinary geometry, got: %T", geom)
+	}
+	env, err := parseEnvelope(blob)
+	if err != nil {
+		return nil, err
+	}
+	return env.Empty, nil
+}
+
+// uuidFunctions are the Go implemented SQL functions generating UUIDs, these can also be used in sql-statements
+var uuidFunctions = map[string]struct {
+	impl any
+	pure bool
+}{
+	"pdok_uuid4":        {uuid4Function, false},
+	"pdok_uuid5":        {uuid5Function, true},
+	"pdok_external_fid": {externalFidFunction, true},
+}
+
+// uuid4Function is the SQL function pdok_uuid4(), returning a random UUID
+func uuid4Function() string {
+	return uuid.New().String()
+}
+
+// uuid5Function is the SQL function pdok_uuid5(namespace, values...), returning the UUIDv5 in the given
+// namespace over the concatenated values
+func uuid5Function(namespace string, values ...any) (string, error) {
+	namespaceUUID, err := uuid.Parse(namespace)
+	if err != nil {
+		return "", fmt.Errorf("invalid namespace UUID '%s': %w", namespace, err)
+	}
+	return uuid5(namespaceUUID, sqlValues(values)), nil
+}
+
+// externalFidFunction is the SQL function pdok_external_fid(table, values...), returning the same external_fid
+// as generateExternalFids for the given values with the legacy algorithm and PDOK namespace
+func externalFidFunction(tableName string, values ...any) (string, error) {
+	pdokNamespaceUUID, err := uuid.Parse(pdokNamespace)
+	if err != nil {
+		return "", err
+	}
+	return externalFid(pdokNamespaceUUID, ExternalFidAlgorithmLegacy, tableName, sqlValues(values)), nil
+}
+
+// sqlValues replaces the nil byte slices the SQLite driver passes for NULL arguments by nil
+func sqlValues(values []any) []any {
+	for i, value := range values {
+		if b, ok := value.([]byte); ok && b == nil {
+			values[i] = nil
+		}
+	}
+	return values
+}
+
+// uuid5 is a UUIDv5 over the concatenated values, NULL values are left out
+func uuid5(namespace uuid.UUID, values []any) string {
+	dataParts := make([]string, 0, len(values))
+	for _, val := range values {
+		if val == nil {
+			dataParts = append(dataParts, "")
+		} else {
+			dataParts = append(dataParts, fmt.Sprintf("%v", val))
+		}
+	}
+	dataString := strings.Join(dataParts, "")
+	return uuid.NewSHA1(namespace, []byte(dataString)).String()
+}
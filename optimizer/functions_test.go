@@ -0,0 +1,41 @@
+package optimizer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestUUIDFunctions(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "functions.gpkg"))
+	if err != nil {
+		t.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+
+	var first, second string
+	if err = db.QueryRow("select pdok_uuid4(), pdok_uuid4()").Scan(&first, &second); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if id, err := uuid.Parse(first); err != nil || id.Version() != 4 {
+		t.Fatalf("pdok_uuid4() returned an invalid UUIDv4: '%s'", first)
+	}
+	if first == second {
+		t.Fatal("pdok_uuid4() returned the same UUID twice")
+	}
+
+	namespace := uuid.MustParse(pdokNamespace)
+	var uuid5, externalFid string
+	err = db.QueryRow("select pdok_uuid5(?, 'pand', 'a', null, 1), pdok_external_fid('pand', 'a', null, 1)", pdokNamespace).Scan(&uuid5, &externalFid)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if expected := uuid.NewSHA1(namespace, []byte("panda1")).String(); uuid5 != expected || externalFid != expected {
+		t.Fatalf("expected '%s', got pdok_uuid5() '%s' and pdok_external_fid() '%s'", expected, uuid5, externalFid)
+	}
+
+	if err = db.QueryRow("select pdok_uuid5('not a uuid', 'a')").Scan(&uuid5); err == nil {
+		t.Fatal("expected an error for an invalid namespace")
+	}
+}
@@ -0,0 +1,191 @@
+package optimizer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// envelope is the 2D bounding box of a geometry
+type envelope struct {
+	MinX, MaxX, MinY, MaxY float64
+	Empty                  bool
+}
+
+func newEmptyEnvelope() envelope {
+	return envelope{
+		MinX:  math.Inf(1),
+		MaxX:  math.Inf(-1),
+		MinY:  math.Inf(1),
+		MaxY:  math.Inf(-1),
+		Empty: true,
+	}
+}
+
+func (e *envelope) extend(x, y float64) {
+	if math.IsNaN(x) || math.IsNaN(y) { // empty points are encoded as NaN coordinates
+		return
+	}
+	e.MinX = math.Min(e.MinX, x)
+	e.MaxX = math.Max(e.MaxX, x)
+	e.MinY = math.Min(e.MinY, y)
+	e.MaxY = math.Max(e.MaxY, y)
+	e.Empty = false
+}
+
+// parseEnvelope determines the envelope of a GeoPackageBinary geometry blob. The envelope stored in the
+// header is used when available, otherwise the envelope is calculated from the WKB body.
+// See http://www.geopackage.org/spec/#gpb_format
+func parseEnvelope(blob []byte) (envelope, error) {
+	env := newEmptyEnvelope()
+	if len(blob) < 8 || blob[0] != 'G' || blob[1] != 'P' {
+		return env, errors.New("not a GeoPackageBinary geometry, missing magic 'GP'")
+	}
+	flags := blob[3]
+	if flags&0x20 != 0 {
+		return env, errors.New("extended GeoPackageBinary geometries are not supported")
+	}
+	if flags&0x10 != 0 { // empty geometry flag
+		return env, nil
+	}
+	var headerOrder binary.ByteOrder = binary.BigEndian
+	if flags&0x01 != 0 {
+		headerOrder = binary.LittleEndian
+	}
+
+	var envelopeSize int
+	switch envelopeIndicator := (flags >> 1) & 0x07; envelopeIndicator {
+	case 0:
+		envelopeSize = 0
+	case 1:
+		envelopeSize = 32 // minx, maxx, miny, maxy
+	case 2, 3:
+		envelopeSize = 48 // minx, maxx, miny, maxy, minz, maxz or minm, maxm
+	case 4:
+		envelopeSize = 64 // minx, maxx, miny, maxy, minz, maxz, minm, maxm
+	default:
+		return env, fmt.Errorf("invalid envelope contents indicator: %d", envelopeIndicator)
+	}
+	if len(blob) < 8+envelopeSize {
+		return env, errors.New("GeoPackageBinary geometry is truncated")
+	}
+	if envelopeSize > 0 {
+		readDouble := func(i int) float64 {
+			return math.Float64frombits(headerOrder.Uint64(blob[8+i*8:]))
+		}
+		env.extend(readDouble(0), readDouble(2))
+		env.extend(readDouble(1), readDouble(3))
+		return env, nil
+	}
+
+	r := &wkbReader{buf: blob[8:]}
+	if err := r.readGeometry(&env); err != nil {
+		return env, err
+	}
+	return env, nil
+}
+
+// wkbReader walks (ISO or extended) Well-Known Binary and collects the coordinates in an envelope
+type wkbReader struct {
+	buf []byte
+	pos int
+}
+
+var errTruncatedWKB = errors.New("WKB geometry is truncated")
+
+func (r *wkbReader) readGeometry(env *envelope) error {
+	if r.pos+5 > len(r.buf) {
+		return errTruncatedWKB
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if r.buf[r.pos] == 1 {
+		order = binary.LittleEndian
+	}
+	r.pos++
+	geomType := order.Uint32(r.buf[r.pos:])
+	r.pos += 4
+
+	dims := 2
+	// extended WKB (EWKB) flags
+	if geomType&0x80000000 != 0 {
+		dims++
+	}
+	if geomType&0x40000000 != 0 {
+		dims++
+	}
+	geomType &= 0x0fffffff
+	// ISO WKB: 1000 = Z, 2000 = M, 3000 = ZM
+	switch geomType / 1000 {
+	case 1, 2:
+		dims++
+	case 3:
+		dims += 2
+	}
+
+	switch geomType % 1000 {
+	case 1: // Point
+		return r.readPoints(order, dims, 1, env)
+	case 2, 8: // LineString, CircularString
+		return r.readPointList(order, dims, env)
+	case 3, 17: // Polygon, Triangle
+		return r.readRings(order, dims, env)
+	case 4, 5, 6, 7, 9, 10, 11, 12, 15, 16: // multi geometries, collections and compound geometries
+		count, err := r.readCount(order)
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < count; i++ {
+			if err = r.readGeometry(env); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported WKB geometry type: %d", geomType)
+	}
+}
+
+func (r *wkbReader) readCount(order binary.ByteOrder) (uint32, error) {
+	if r.pos+4 > len(r.buf) {
+		return 0, errTruncatedWKB
+	}
+	count := order.Uint32(r.buf[r.pos:])
+	r.pos += 4
+	return count, nil
+}
+
+func (r *wkbReader) readPoints(order binary.ByteOrder, dims int, count uint32, env *envelope) error {
+	size := int(count) * dims * 8
+	if count > uint32(len(r.buf)) || r.pos+size > len(r.buf) {
+		return errTruncatedWKB
+	}
+	for i := uint32(0); i < count; i++ {
+		x := math.Float64frombits(order.Uint64(r.buf[r.pos:]))
+		y := math.Float64frombits(order.Uint64(r.buf[r.pos+8:]))
+		env.extend(x, y)
+		r.pos += dims * 8
+	}
+	return nil
+}
+
+func (r *wkbReader) readPointList(order binary.ByteOrder, dims int, env *envelope) error {
+	count, err := r.readCount(order)
+	if err != nil {
+		return err
+	}
+	return r.readPoints(order, dims, count, env)
+}
+
+func (r *wkbReader) readRings(order binary.ByteOrder, dims int, env *envelope) error {
+	count, err := r.readCount(order)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		if err = r.readPointList(order, dims, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
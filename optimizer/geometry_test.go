@@ -0,0 +1,78 @@
+package optimizer
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+// gpkgBlob builds a little endian GeoPackageBinary geometry with the given envelope (optional) and WKB
+func gpkgBlob(env []float64, wkb []byte) []byte {
+	flags := byte(0x01)
+	if len(env) == 4 {
+		flags |= 1 << 1
+	}
+	blob := []byte{'G', 'P', 0, flags, 0x40, 0x71, 0, 0}
+	for _, v := range env {
+		blob = binary.LittleEndian.AppendUint64(blob, math.Float64bits(v))
+	}
+	return append(blob, wkb...)
+}
+
+func wkbPolygon(coords ...float64) []byte {
+	wkb := []byte{1}
+	wkb = binary.LittleEndian.AppendUint32(wkb, 3)
+	wkb = binary.LittleEndian.AppendUint32(wkb, 1)
+	wkb = binary.LittleEndian.AppendUint32(wkb, uint32(len(coords)/2))
+	for _, v := range coords {
+		wkb = binary.LittleEndian.AppendUint64(wkb, math.Float64bits(v))
+	}
+	return wkb
+}
+
+func TestParseEnvelope(t *testing.T) {
+	// point from geopackage/original_ows.gpkg, without envelope in header
+	point, _ := hex.DecodeString("475000014071000001E903000073E988140DB00741E48D6D5EB8A61C410000000000000000")
+	polygon := wkbPolygon(0, 0, 10, 0, 10, 5, 0, 5, 0, 0)
+	multiPolygon := append(binary.LittleEndian.AppendUint32([]byte{1, 6, 0, 0, 0}, 2), append(polygon, wkbPolygon(-3, 2, 1, 2, 1, 8, -3, 2)...)...)
+
+	tests := []struct {
+		name     string
+		blob     []byte
+		expected envelope
+	}{
+		{"point z", point, envelope{MinX: 194049.635026764, MaxX: 194049.635026764, MinY: 469422.092214791, MaxY: 469422.092214791}},
+		{"polygon", gpkgBlob(nil, polygon), envelope{MinX: 0, MaxX: 10, MinY: 0, MaxY: 5}},
+		{"polygon with envelope", gpkgBlob([]float64{1, 2, 3, 4}, polygon), envelope{MinX: 1, MaxX: 2, MinY: 3, MaxY: 4}},
+		{"multipolygon", gpkgBlob(nil, multiPolygon), envelope{MinX: -3, MaxX: 10, MinY: 0, MaxY: 8}},
+		{"empty", []byte{'G', 'P', 0, 0x11, 0, 0, 0, 0}, newEmptyEnvelope()},
+	}
+	for _, tt := range tests {
+		env, err := parseEnvelope(tt.blob)
+		if err != nil {
+			t.Fatalf("%s: error parsing envelope: %s", tt.name, err)
+		}
+		if env.Empty != tt.expected.Empty {
+			t.Fatalf("%s: expected empty to be %t", tt.name, tt.expected.Empty)
+		}
+		if !env.Empty && (math.Abs(env.MinX-tt.expected.MinX) > 1e-6 || math.Abs(env.MaxX-tt.expected.MaxX) > 1e-6 ||
+			math.Abs(env.MinY-tt.expected.MinY) > 1e-6 || math.Abs(env.MaxY-tt.expected.MaxY) > 1e-6) {
+			t.Fatalf("%s: unexpected envelope: %+v", tt.name, env)
+		}
+	}
+
+	if _, err := parseEnvelope(gpkgBlob(nil, polygon[:20])); err == nil {
+		t.Fatal("expected error for truncated geometry")
+	}
+}
+
+func TestEnvelopeFunctionInvalidGeometry(t *testing.T) {
+	minX := envelopeFunction(envelopeFunctions["pdok_minx"])
+	for _, geom := range []any{[]byte("not a geometry"), gpkgBlob(nil, []byte{1, 3}), "geom", int64(1)} {
+		value, err := minX(geom)
+		if err != nil || value != nil {
+			t.Fatalf("expected NULL for invalid geometry %v, got: %v (%v)", geom, value, err)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DefaultSampleSize is the number of rows per table sampled by Inspect
+const DefaultSampleSize = 10000
+
+// Profile describes the tables of a GeoPackage, to help writing a config for it
+type Profile struct {
+	Tables []TableProfile `json:"tables"`
+}
+
+// TableProfile describes a table registered in gpkg_contents, with its columns and indexes
+type TableProfile struct {
+	Table       string `json:"table"`
+	DataType    string `json:"dataType"`
+	Identifier  string `json:"identifier,omitempty"`
+	Description string `json:"description,omitempty"`
+	Rows        int64  `json:"rows"`
+	// SampledRows is the number of rows the column statistics are based on, these are exact when all rows are sampled
+	SampledRows    int64           `json:"sampledRows"`
+	FidColumn      string          `json:"fidColumn,omitempty"`
+	GeometryColumn string          `json:"geometryColumn,omitempty"`
+	GeometryType   string          `json:"geometryType,omitempty"`
+	SRS            *SRSProfile     `json:"srs,omitempty"`
+	Columns        []ColumnProfile `json:"columns"`
+	Indexes        []IndexProfile  `json:"indexes"`
+}
+
+// SRSProfile is the spatial reference system of a table, as registered in gpkg_spatial_ref_sys
+type SRSProfile struct {
+	ID                     int64  `json:"id"`
+	Name                   string `json:"name"`
+	Organization           string `json:"organization"`
+	OrganizationCoordsysID int64  `json:"organizationCoordsysId"`
+}
+
+// ColumnProfile describes a column and its sampled values
+type ColumnProfile struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	NotNull    bool   `json:"notNull"`
+	PrimaryKey bool   `json:"primaryKey"`
+	Nulls      int64  `json:"nulls"`
+	// Distinct is the number of distinct values in the sample
+	Distinct int64 `json:"distinct"`
+	// Cardinality estimates the number of distinct values in the table
+	Cardinality int64 `json:"cardinality"`
+	// Unique reports whether the sampled values are unique and not NULL
+	Unique bool `json:"unique"`
+	// DateLike reports whether the column has a date or time type, or all sampled values are ISO 8601 dates
+	DateLike bool `json:"dateLike"`
+	// CandidateExternalFid suggests the column for external-fid-columns
+	CandidateExternalFid bool `json:"candidateExternalFid"`
+	// CandidateTemporal suggests the column for temporal-columns
+	CandidateTemporal bool `json:"candidateTemporal"`
+}
+
+// IndexProfile is an existing index on a table
+type IndexProfile struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// String renders the profile as human-readable text
+func (p *Profile) String() string {
+	var sb strings.Builder
+	for i, t := range p.Tables {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "table '%s' (%s, %d rows", t.Table, t.DataType, t.Rows)
+		if t.SampledRows < t.Rows {
+			fmt.Fprintf(&sb, ", %d sampled", t.SampledRows)
+		}
+		sb.WriteString(")\n")
+		if t.FidColumn != "" {
+			fmt.Fprintf(&sb, "  fid: %s\n", t.FidColumn)
+		}
+		if t.GeometryColumn != "" {
+			fmt.Fprintf(&sb, "  geometry: %s (%s)", t.GeometryColumn, t.GeometryType)
+			if t.SRS != nil {
+				fmt.Fprintf(&sb, ", %s:%d %s", t.SRS.Organization, t.SRS.OrganizationCoordsysID, t.SRS.Name)
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("  columns:\n")
+		for _, c := range t.Columns {
+			if strings.EqualFold(c.Name, t.GeometryColumn) {
+				fmt.Fprintf(&sb, "    %s %s [geometry]\n", c.Name, c.Type)
+				continue
+			}
+			fmt.Fprintf(&sb, "    %s %s: %d distinct (~%d), %d null", c.Name, c.Type, c.Distinct, c.Cardinality, c.Nulls)
+			var notes []string
+			if c.PrimaryKey {
+				notes = append(notes, "primary key")
+			}
+			if c.Unique {
+				notes = append(notes, "unique")
+			}
+			if c.DateLike {
+				notes = append(notes, "date-like")
+			}
+			if c.CandidateExternalFid {
+				notes = append(notes, "external-fid candidate")
+			}
+			if c.CandidateTemporal {
+				notes = append(notes, "temporal candidate")
+			}
+			if len(notes) > 0 {
+				fmt.Fprintf(&sb, " [%s]", strings.Join(notes, ", "))
+			}
+			sb.WriteString("\n")
+		}
+		if len(t.Indexes) > 0 {
+			sb.WriteString("  indexes:\n")
+			for _, index := range t.Indexes {
+				unique := ""
+				if index.Unique {
+					unique = "unique "
+				}
+				fmt.Fprintf(&sb, "    %s: %s(%s)\n", index.Name, unique, strings.Join(index.Columns, ", "))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// InspectFile opens the GeoPackage at the given path and profiles it, without changing the GeoPackage
+func InspectFile(ctx context.Context, geopackage string, sampleSize int) (*Profile, error) {
+	db, err := openExisting(geopackage)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return Inspect(ctx, db, sampleSize)
+}
+
+// Inspect profiles the tables registered in gpkg_contents: their geometry type and SRS, (estimated) row count, columns
+// and indexes. Per column the first sampleSize rows are sampled to count the NULL and distinct values and to find
+// columns that are unique or hold dates, which are suggested for external-fid-columns and temporal-columns.
+func Inspect(ctx context.Context, db *sql.DB, sampleSize int) (*Profile, error) {
+	if sampleSize <= 0 {
+		sampleSize = DefaultSampleSize
+	}
+	tables, err := getTables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	profile := &Profile{Tables: []TableProfile{}}
+	for _, table := range tables {
+		t, err := inspectTable(ctx, table, sampleSize, db)
+		if err != nil {
+			return nil, err
+		}
+		profile.Tables = append(profile.Tables, *t)
+	}
+	return profile, nil
+}
+
+func inspectTable(ctx context.Context, table gpkgTable, sampleSize int, db *sql.DB) (*TableProfile, error) {
+	t := &TableProfile{Table: table.Name, DataType: table.DataType, GeometryColumn: table.GeometryColumn}
+	var identifier, description sql.NullString
+	err := db.QueryRowContext(ctx, "SELECT identifier, description FROM gpkg_contents WHERE lower(table_name) = lower(?)",
+		table.Name).Scan(&identifier, &description)
+	if err != nil {
+		return nil, newError(StepInspectSchema, table.Name, "", err)
+	}
+	t.Identifier, t.Description = identifier.String, description.String
+	if t.Rows, err = estimateRowCount(ctx, table.Name, db); err != nil {
+		return nil, err
+	}
+	if t.FidColumn, err = getIntegerPrimaryKeyColumn(ctx, table.Name, db); err != nil {
+		return nil, err
+	}
+	if table.GeometryColumn != "" {
+		if err = inspectGeometry(ctx, t, db); err != nil {
+			return nil, err
+		}
+	}
+	if err = inspectColumns(ctx, t, sampleSize, db); err != nil {
+		return nil, err
+	}
+	if err = inspectIndexes(ctx, t, db); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func inspectGeometry(ctx context.Context, t *TableProfile, db *sql.DB) error {
+	var srsID int64
+	err := db.QueryRowContext(ctx,
+		"SELECT geometry_type_name, srs_id FROM gpkg_geometry_columns WHERE lower(table_name) = lower(?)",
+		t.Table).Scan(&t.GeometryType, &srsID)
+	if err != nil {
+		return newError(StepInspectSchema, t.Table, t.GeometryColumn, err)
+	}
+	srs := SRSProfile{ID: srsID}
+	err = db.QueryRowContext(ctx,
+		"SELECT srs_name, organization, organization_coordsys_id FROM gpkg_spatial_ref_sys WHERE srs_id = ?",
+		srsID).Scan(&srs.Name, &srs.Organization, &srs.OrganizationCoordsysID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return newError(StepInspectSchema, t.Table, t.GeometryColumn, err)
+	}
+	t.SRS = &srs
+	return nil
+}
+
+// isoDatePattern matches the text SQLite and GDAL store dates and datetimes as
+const isoDatePattern = "[0-9][0-9][0-9][0-9]-[0-1][0-9]-[0-3][0-9]*"
+
+func inspectColumns(ctx context.Context, t *TableProfile, sampleSize int, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "SELECT name, type, \"notnull\", pk FROM pragma_table_info(?) ORDER BY cid", t.Table)
+	if err != nil {
+		return newError(StepInspectSchema, t.Table, "", err)
+	}
+	for rows.Next() {
+		var c ColumnProfile
+		var pk int
+		if err = rows.Scan(&c.Name, &c.Type, &c.NotNull, &pk); err != nil {
+			rows.Close()
+			return newError(StepInspectSchema, t.Table, "", err)
+		}
+		c.PrimaryKey = pk > 0
+		t.Columns = append(t.Columns, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return newError(StepInspectSchema, t.Table, "", err)
+	}
+
+	// a single pass over the sample computes the statistics of all columns, the geometry isn't sampled
+	selects := []string{"count(*)"}
+	for _, c := range t.Columns {
+		if strings.EqualFold(c.Name, t.GeometryColumn) {
+			continue
+		}
+		selects = append(selects,
+			fmt.Sprintf("count(%s)", quoteIdentifier(c.Name)),
+			fmt.Sprintf("count(DISTINCT %s)", quoteIdentifier(c.Name)),
+			fmt.Sprintf("count(CASE WHEN typeof(%[1]s) = 'text' AND %[1]s GLOB '%[2]s' THEN 1 END)", quoteIdentifier(c.Name), isoDatePattern))
+	}
+	query := fmt.Sprintf("SELECT %s FROM (SELECT * FROM %s LIMIT %d)", strings.Join(selects, ", "), quoteIdentifier(t.Table), sampleSize)
+	values := make([]int64, len(selects))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = db.QueryRowContext(ctx, query).Scan(dest...); err != nil {
+		return newError(StepInspectSchema, t.Table, "", fmt.Errorf("error sampling rows: %w", err))
+	}
+
+	t.SampledRows = values[0]
+	i := 1
+	for n := range t.Columns {
+		c := &t.Columns[n]
+		if strings.EqualFold(c.Name, t.GeometryColumn) {
+			continue
+		}
+		nonNull, distinct, dates := values[i], values[i+1], values[i+2]
+		i += 3
+		c.Nulls = t.SampledRows - nonNull
+		c.Distinct = distinct
+		c.Unique = nonNull > 0 && c.Nulls == 0 && distinct == nonNull
+		c.Cardinality = estimateCardinality(distinct, t.SampledRows, t.Rows, c.Unique)
+		c.DateLike = isDateType(c.Type) || (nonNull > 0 && dates == nonNull)
+		optimizerColumn := isOptimizerColumn(c.Name)
+		c.CandidateExternalFid = c.Unique && !optimizerColumn && !strings.EqualFold(c.Name, t.FidColumn) &&
+			!c.DateLike && !isFloatType(c.Type)
+		c.CandidateTemporal = c.DateLike && !optimizerColumn
+	}
+	return nil
+}
+
+// estimateCardinality extrapolates the distinct values in the sample to the table: unique columns are assumed to stay
+// unique, otherwise the distinct values of the sample are a lower bound
+func estimateCardinality(distinct int64, sampled int64, rows int64, unique bool) int64 {
+	if sampled >= rows || !unique {
+		return distinct
+	}
+	return rows
+}
+
+func isDateType(columnType string) bool {
+	switch strings.ToLower(columnType) {
+	case "date", "datetime", "timestamp":
+		return true
+	}
+	return false
+}
+
+func isFloatType(columnType string) bool {
+	switch strings.ToLower(columnType) {
+	case "real", "float", "double", "numeric":
+		return true
+	}
+	return false
+}
+
+// isOptimizerColumn reports whether the column is one the optimizer adds
+func isOptimizerColumn(columnName string) bool {
+	switch strings.ToLower(columnName) {
+	case "puuid", "fuuid", "external_fid", "minx", "maxx", "miny", "maxy":
+		return true
+	}
+	return false
+}
+
+func inspectIndexes(ctx context.Context, t *TableProfile, db *sql.DB) error {
+	t.Indexes = []IndexProfile{}
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_index_list(?) WHERE origin = 'c' ORDER BY name", t.Table)
+	if err != nil {
+		return newError(StepInspectSchema, t.Table, "", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return newError(StepInspectSchema, t.Table, "", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return newError(StepInspectSchema, t.Table, "", err)
+	}
+	for _, name := range names {
+		index, err := getIndex(ctx, name, db)
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			t.Indexes = append(t.Indexes, IndexProfile{Name: name, Columns: index.Columns, Unique: index.Unique})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package optimizer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	profile, err := InspectFile(context.Background(), "../geopackage/original_oaf.gpkg", 0)
+	if err != nil {
+		t.Fatalf("error inspecting GeoPackage: %s", err)
+	}
+
+	var pand *TableProfile
+	for i := range profile.Tables {
+		if profile.Tables[i].Table == "pand" {
+			pand = &profile.Tables[i]
+		}
+	}
+	if pand == nil {
+		t.Fatalf("expected table 'pand' in:\n%s", profile)
+	}
+	if pand.Rows != 4 || pand.SampledRows != 4 || pand.FidColumn != "fid" || pand.GeometryColumn != "geom" || pand.GeometryType != "POLYGON" {
+		t.Fatalf("unexpected profile of table 'pand': %+v", pand)
+	}
+	if pand.SRS == nil || pand.SRS.Organization != "EPSG" || pand.SRS.OrganizationCoordsysID != 28992 {
+		t.Fatalf("expected SRS EPSG:28992, got: %+v", pand.SRS)
+	}
+
+	columns := make(map[string]ColumnProfile)
+	for _, c := range pand.Columns {
+		columns[c.Name] = c
+	}
+	identificatie := columns["identificatie"]
+	if !identificatie.Unique || !identificatie.CandidateExternalFid || identificatie.CandidateTemporal || identificatie.Cardinality != 4 {
+		t.Fatalf("expected identificatie to be an external-fid candidate, got: %+v", identificatie)
+	}
+	bouwjaar := columns["bouwjaar"]
+	if !bouwjaar.DateLike || !bouwjaar.CandidateTemporal || bouwjaar.CandidateExternalFid {
+		t.Fatalf("expected bouwjaar to be a temporal candidate, got: %+v", bouwjaar)
+	}
+	if fid := columns["fid"]; !fid.PrimaryKey || fid.CandidateExternalFid {
+		t.Fatalf("expected fid to be the primary key and not an external-fid candidate, got: %+v", fid)
+	}
+}
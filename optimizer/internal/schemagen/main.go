@@ -0,0 +1,44 @@
+// Command schemagen generates the published JSON Schemas for the optimizer configs from
+// optimizer.OafConfig and optimizer.OwsConfig. Run through 'go generate' in the optimizer package.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/PDOK/geopackage-optimizer-go/optimizer"
+	"github.com/invopop/jsonschema"
+)
+
+const schemaBaseURL = "https://github.com/PDOK/geopackage-optimizer-go/optimizer/schema/"
+
+func main() {
+	for name, config := range map[string]any{
+		"oaf-config.schema.json": &optimizer.OafConfig{},
+		"ows-config.schema.json": &optimizer.OwsConfig{},
+	} {
+		schema, err := generate(name, config)
+		if err != nil {
+			log.Fatalf("error generating JSON Schema '%s': %s", name, err)
+		}
+		if err = os.WriteFile(filepath.Join("schema", name), schema, 0644); err != nil {
+			log.Fatalf("error writing JSON Schema '%s': %s", name, err)
+		}
+	}
+}
+
+func generate(name string, config any) ([]byte, error) {
+	reflector := &jsonschema.Reflector{
+		// fields are optional unless tagged as required
+		RequiredFromJSONSchemaTags: true,
+	}
+	schema := reflector.Reflect(config)
+	schema.ID = jsonschema.ID(schemaBaseURL + name)
+	result, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(result, '\n'), nil
+}
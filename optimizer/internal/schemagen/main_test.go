@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PDOK/geopackage-optimizer-go/optimizer"
+)
+
+func TestSchemasUpToDate(t *testing.T) {
+	for name, config := range map[string]any{
+		"oaf-config.schema.json": &optimizer.OafConfig{},
+		"ows-config.schema.json": &optimizer.OwsConfig{},
+	} {
+		expected, err := generate(name, config)
+		if err != nil {
+			t.Fatalf("error generating JSON Schema '%s': %s", name, err)
+		}
+		actual, err := os.ReadFile(filepath.Join("..", "..", "schema", name))
+		if err != nil {
+			t.Fatalf("error reading JSON Schema '%s': %s", name, err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Fatalf("JSON Schema '%s' is outdated, run 'go generate ./...' in the optimizer package", name)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+func optimizeOAFLayer(ctx context.Context, table gpkgTable, layerCfg Layer, duplicates *[]DuplicateExternalFid, db dbtx) error {
+	tableName := table.Name
+	// any configured SQL statements are executed first, to allow maximum configuration freedom if needed
+	for _, stmt := range layerCfg.SQLStatements {
+		if err := executeQuery(ctx, tableName, stmt, db); err != nil {
+			return err
+		}
+	}
+
+	fidColumn, geomColumn, err := resolveColumns(ctx, table, layerCfg.FidColumn, layerCfg.GeomColumn, db)
+	if err != nil {
+		return err
+	}
+	layerCfg.FidColumn, layerCfg.GeomColumn = fidColumn, geomColumn
+
+	if len(layerCfg.ExternalFidColumns) > 0 {
+		found, err := generateExternalFids(ctx, tableName, layerCfg, db)
+		*duplicates = append(*duplicates, found...)
+		if err != nil {
+			return err
+		}
+		if err := createIndex(ctx, tableName, []string{"external_fid"}, fmt.Sprintf("%s_external_fid_idx", tableName), uniqueExternalFid(layerCfg), db); err != nil {
+			return err
+		}
+	}
+
+	if len(layerCfg.TemporalColumns) > 0 {
+		if err := createIndex(ctx, tableName, layerCfg.TemporalColumns, fmt.Sprintf("%s_temporal_idx", tableName), false, db); err != nil {
+			return err
+		}
+	}
+
+	for _, relation := range layerCfg.Relations {
+		if err := createRelation(ctx, tableName, layerCfg.FidColumn, relation, db); err != nil {
+			return err
+		}
+	}
+
+	if table.DataType != dataTypeFeatures {
+		// without geometry there's no spatial index, but the temporal columns are still indexed above
+		return nil
+	}
+	return addOAFDefaultOptimizations(ctx, tableName, layerCfg.FidColumn, layerCfg.GeomColumn, layerCfg.TemporalColumns, db)
+}
+
+// generateExternalFids adds and fills the external_fid column, after which the duplicate external_fids are handled
+// according to the configured policy. Returns the duplicates found.
+func generateExternalFids(ctx context.Context, tableName string, layerCfg Layer, db dbtx) ([]DuplicateExternalFid, error) {
+	// SQLite reads a quoted name that isn't a column as a string literal, so the columns have to be checked first. The
+	// configuration is validated before anything is added or registered.
+	columns := make([]string, len(layerCfg.ExternalFidColumns))
+	for i, column := range layerCfg.ExternalFidColumns {
+		if err := checkColumnExists(ctx, tableName, column, "external-fid-column", db); err != nil {
+			return nil, err
+		}
+		columns[i] = quoteIdentifier(column)
+	}
+	if layerCfg.ExternalFidTiebreaker != "" {
+		if err := checkColumnExists(ctx, tableName, layerCfg.ExternalFidTiebreaker, "external-fid-tiebreaker", db); err != nil {
+			return nil, err
+		}
+	}
+	namespace, err := externalFidNamespace(layerCfg)
+	if err != nil {
+		return nil, newError(StepGenerateExternalFid, tableName, "external_fid", fmt.Errorf("failed to parse namespace UUID: %w", err))
+	}
+
+	if err = addColumn(ctx, tableName, "external_fid", "TEXT", db); err != nil {
+		return nil, err
+	}
+	if err = registerExternalFid(ctx, tableName, layerCfg, namespace, db); err != nil {
+		return nil, err
+	}
+	singlePass, err := externalFidSinglePass(ctx, tableName, layerCfg, namespace, db)
+	if err != nil {
+		return nil, err
+	}
+	if singlePass {
+		// pdok_external_fid calculates the same external_fid in SQL, so the column is filled in a single UPDATE
+		value := fmt.Sprintf("pdok_external_fid('%s', %s)", strings.ReplaceAll(tableName, "'", "''"), strings.Join(columns, ", "))
+		if err = setColumnValue(ctx, tableName, "external_fid", value, db); err != nil {
+			return nil, err
+		}
+		return checkExternalFidDuplicates(ctx, tableName, layerCfg, namespace, db)
+	}
+
+	if _, ok := db.(*planner); !ok {
+		slog.Info("generating external_fid", "table", tableName, "columns", layerCfg.ExternalFidColumns, "algorithm", layerCfg.ExternalFidAlgorithm)
+	}
+	_, err = updateRows(ctx, db, rowUpdate{
+		table:         tableName,
+		step:          StepGenerateExternalFid,
+		columns:       []string{"external_fid"},
+		selectColumns: columns,
+		description:   fmt.Sprintf("UUIDv5 (%s) per row based on columns %v", layerCfg.ExternalFidAlgorithm, layerCfg.ExternalFidColumns),
+		resumable:     true,
+		compute: func(values []any) ([]any, error) {
+			return []any{externalFid(namespace, layerCfg.ExternalFidAlgorithm, tableName, values)}, nil
+		},
+	})
+	if err != nil {
+		return nil, newError(StepGenerateExternalFid, tableName, "external_fid", err)
+	}
+	return checkExternalFidDuplicates(ctx, tableName, layerCfg, namespace, db)
+}
+
+// externalFidSinglePass reports whether the external_fid can be filled in a single pass. pdok_external_fid only
+// implements the legacy algorithm in the PDOK namespace. Date, time and boolean columns are converted by the SQLite
+// driver when read in Go, which pdok_external_fid can't reproduce, so the external_fid of tables using these is always
+// generated in Go to keep it stable.
+func externalFidSinglePass(ctx context.Context, tableName string, layerCfg Layer, namespace uuid.UUID, db dbtx) (bool, error) {
+	if !runOptions(db).singlePass {
+		return false, nil
+	}
+	if layerCfg.ExternalFidAlgorithm != ExternalFidAlgorithmLegacy || namespace.String() != pdokNamespace {
+		slog.Info("external_fid algorithm or namespace isn't supported by pdok_external_fid, generating it in Go instead of in a single pass",
+			"table", tableName, "algorithm", layerCfg.ExternalFidAlgorithm, "namespace", namespace)
+		return false, nil
+	}
+	for _, column := range layerCfg.ExternalFidColumns {
+		columnType, err := getColumnType(ctx, tableName, column, db)
+		if err != nil {
+			return false, err
+		}
+		switch columnType {
+		case "date", "datetime", "timestamp", "boolean":
+			slog.Info("external_fid is based on a column pdok_external_fid can't reproduce, generating it in Go instead of in a single pass",
+				"table", tableName, "column", column, "type", columnType)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+var envelopeColumns = []struct {
+	column   string
+	function string
+}{
+	{"minx", "pdok_minx"},
+	{"maxx", "pdok_maxx"},
+	{"miny", "pdok_miny"},
+	{"maxy", "pdok_maxy"},
+}
+
+func addOAFDefaultOptimizations(ctx context.Context, tableName string, fidColumn string, geomColumn string, temporalColumns []string, db dbtx) error {
+	columnNames := make([]string, len(envelopeColumns))
+	values := make([]string, len(envelopeColumns))
+	for i, e := range envelopeColumns {
+		if err := addColumn(ctx, tableName, e.column, "numeric", db); err != nil {
+			return err
+		}
+		columnNames[i] = e.column
+		values[i] = fmt.Sprintf("%s(%s)", e.function, quoteIdentifier(geomColumn))
+	}
+	if err := setEnvelopes(ctx, tableName, geomColumn, columnNames, values, db); err != nil {
+		return err
+	}
+
+	spatialColumns := []string{fidColumn, "minx", "maxx", "miny", "maxy"}
+	if temporalColumns != nil {
+		spatialColumns = append(spatialColumns, temporalColumns...)
+	}
+	return createIndex(ctx, tableName, spatialColumns, fmt.Sprintf("%s_spatial_idx", tableName), false, db)
+}
+
+// setEnvelopes fills the envelope columns. The envelope is calculated by Go implemented functions, which don't depend
+// on SpatiaLite, in a single UPDATE. With multiple workers or when committing per chunk the envelopes are calculated
+// in chunks instead, unless a single pass is requested.
+func setEnvelopes(ctx context.Context, tableName string, geomColumn string, columnNames []string, values []string, db dbtx) error {
+	o := runOptions(db)
+	if !o.chunked() {
+		return setColumnValues(ctx, tableName, columnNames, values, db)
+	}
+
+	slog.Info("calculating envelopes in chunks", "table", tableName, "chunkSize", o.chunkSize, "workers", o.workers)
+	_, err := updateRows(ctx, db, rowUpdate{
+		table:         tableName,
+		step:          StepSetColumnValue,
+		columns:       columnNames,
+		selectColumns: []string{quoteIdentifier(geomColumn)},
+		description:   fmt.Sprintf("envelope of '%s' per row", geomColumn),
+		resumable:     true,
+		compute: func(values []any) ([]any, error) {
+			env, ok := envelopeOrNull(values[0])
+			if !ok {
+				return []any{nil, nil, nil, nil}, nil
+			}
+			return []any{env.MinX, env.MaxX, env.MinY, env.MaxY}, nil
+		},
+	})
+	if err != nil {
+		return newError(StepSetColumnValue, tableName, strings.Join(columnNames, ","), err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package optimizer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/creasty/defaults"
+	"github.com/google/uuid"
+)
+
+type OafConfig struct {
+	Layers            map[string]Layer `json:"layers" jsonschema_description:"Config per GeoPackage table, keyed by table name"`
+	ExternalFidReport string           `json:"external-fid-report,omitempty" jsonschema_description:"File to write the rows sharing an external_fid to, as JSON"`
+}
+
+type Layer struct {
+	FidColumn             string     `json:"fid-column,omitempty" jsonschema_description:"The fid column, defaults to the integer primary key and may not conflict with it"`
+	GeomColumn            string     `json:"geom-column,omitempty" jsonschema_description:"Overrides the geometry column, defaults to the column registered in gpkg_geometry_columns"`
+	SQLStatements         []string   `json:"sql-statements,omitempty" jsonschema_description:"SQL statements executed before any other optimization"`
+	ExternalFidColumns    []string   `json:"external-fid-columns,omitempty" jsonschema:"minItems=1" jsonschema_description:"Columns that are functionally unique across time, used to generate the external_fid"`
+	ExternalFidNamespace  string     `json:"external-fid-namespace,omitempty" jsonschema_description:"UUID namespace of the external_fid UUIDv5, defaults to the PDOK namespace"`
+	ExternalFidAlgorithm  string     `json:"external-fid-algorithm,omitempty" default:"legacy" jsonschema:"enum=legacy,enum=canonical-v1,default=legacy" jsonschema_description:"How the external-fid-columns are combined into the name of the UUIDv5, 'canonical-v1' is recommended for new datasets"`
+	ExternalFidDuplicates string     `json:"external-fid-duplicates,omitempty" default:"warn" jsonschema:"enum=warn,enum=fail,enum=unique,enum=tiebreaker,default=warn" jsonschema_description:"What to do when rows share an external_fid: 'warn', 'fail', 'unique' (fail, and enforce unique external_fids afterwards with a UNIQUE index) or 'tiebreaker' (append the external-fid-tiebreaker column to the values of the rows involved)"`
+	ExternalFidTiebreaker string     `json:"external-fid-tiebreaker,omitempty" jsonschema_description:"Column appended to the external-fid-columns of rows sharing an external_fid, with external-fid-duplicates 'tiebreaker'"`
+	TemporalColumns       []string   `json:"temporal-columns,omitempty" jsonschema_description:"Columns to add to the temporal and spatial index"`
+	Relations             []Relation `json:"relations,omitempty"`
+}
+
+type Relation struct {
+	Table        string          `json:"table" jsonschema:"required" jsonschema_description:"Related table"`
+	Columns      RelationColumns `json:"columns" jsonschema:"required"`
+	MappingTable string          `json:"mapping-table,omitempty" jsonschema_description:"Name of the mapping table linking the features to the related rows, defaults to '<layer>_<table>'"`
+}
+
+type RelationColumns struct {
+	ForeignKey string `json:"fk" jsonschema:"required" jsonschema_description:"Column in the layer table referring to the related table"`
+	PrimaryKey string `json:"pk" jsonschema:"required" jsonschema_description:"Column in the related table that is referred to"`
+}
+
+// ParseOafConfig parses the given JSON or YAML config, validates it against the
+// OAF config JSON Schema and applies the defaults
+func ParseOafConfig(config []byte) (*OafConfig, error) {
+	var oafConfig OafConfig
+	err := decodeConfig(config, oafConfigSchemaName, &oafConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err = oafConfig.setDefaults(); err != nil {
+		return nil, err
+	}
+	if err = oafConfig.validate(); err != nil {
+		return nil, err
+	}
+	return &oafConfig, nil
+}
+
+func (c *OafConfig) setDefaults() error {
+	err := defaults.Set(c)
+	if err != nil {
+		return newError(StepConfig, "", "", fmt.Errorf("failed to set default config: %w", err))
+	}
+	return nil
+}
+
+func (c *OafConfig) validate() error {
+	for name, layer := range c.Layers {
+		if _, ok := externalFidAlgorithms[layer.ExternalFidAlgorithm]; !ok {
+			return newError(StepConfig, name, "", fmt.Errorf("unknown external-fid-algorithm '%s'", layer.ExternalFidAlgorithm))
+		}
+		switch layer.ExternalFidDuplicates {
+		case ExternalFidDuplicatesWarn, ExternalFidDuplicatesFail, ExternalFidDuplicatesUnique:
+		case ExternalFidDuplicatesTiebreaker:
+			if layer.ExternalFidTiebreaker == "" {
+				return newError(StepConfig, name, "", errors.New("external-fid-duplicates 'tiebreaker' requires external-fid-tiebreaker"))
+			}
+		default:
+			return newError(StepConfig, name, "", fmt.Errorf("unknown external-fid-duplicates policy '%s'", layer.ExternalFidDuplicates))
+		}
+		if layer.ExternalFidNamespace != "" {
+			if _, err := uuid.Parse(layer.ExternalFidNamespace); err != nil {
+				return newError(StepConfig, name, "", fmt.Errorf("invalid external-fid-namespace '%s': %w", layer.ExternalFidNamespace, err))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package optimizer optimizes GeoPackages so that they can be used as datasource
+// for (PDOK) OGC services and APIs.
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+const (
+	pdokNamespace = "098c4e26-6e36-5693-bae9-df35db0bee49"
+)
+
+// OptimizeOWSFile opens the GeoPackage at the given path and performs the OWS optimizations on it
+func OptimizeOWSFile(ctx context.Context, sourceGeopackage string, config *OwsConfig, opts ...Option) error {
+	slog.Info("performing OWS optimizations", "geopackage", sourceGeopackage)
+	return optimizeFile(ctx, sourceGeopackage, newOptions(opts), func(db *sql.DB) error {
+		return OptimizeOWS(ctx, db, config, opts...)
+	})
+}
+
+// OptimizeOAFFile opens the GeoPackage at the given path and performs the OAF optimizations on it
+func OptimizeOAFFile(ctx context.Context, sourceGeopackage string, config *OafConfig, opts ...Option) error {
+	slog.Info("performing OAF optimizations", "geopackage", sourceGeopackage)
+	return optimizeFile(ctx, sourceGeopackage, newOptions(opts), func(db *sql.DB) error {
+		return OptimizeOAF(ctx, db, config, opts...)
+	})
+}
+
+// OptimizeOWS performs the OWS optimizations on the given GeoPackage. The config is optional.
+func OptimizeOWS(ctx context.Context, db *sql.DB, config *OwsConfig, opts ...Option) (err error) {
+	o := newOptions(opts)
+	start := time.Now()
+	o.report.begin(ctx, "ows", db)
+	defer o.report.finish(start, &err)
+
+	units, removed, err := owsUnits(ctx, db, config, o)
+	if err != nil {
+		return err
+	}
+	run, err := startRun(ctx, db, "ows", config, start)
+	if err != nil {
+		return err
+	}
+	if err = transactional(ctx, db, o, units, func(tx dbtx) error {
+		return run.record(ctx, tx)
+	}); err != nil {
+		return err
+	}
+	if config != nil && config.PUUID != nil && config.PUUID.Mode == PUUIDModePreviousRelease {
+		slog.Info("puuids of the previous release no longer exist", "rows", len(*removed))
+		if config.PUUID.RemovedReport != "" {
+			return writeRemovedPUUIDs(config.PUUID.RemovedReport, *removed)
+		}
+	}
+	return nil
+}
+
+// OptimizeOAF performs the OAF optimizations on the given GeoPackage. The config is optional,
+// when omitted only the default optimizations are performed. The fid and geometry columns are
+// detected from the GeoPackage metadata, unless configured.
+func OptimizeOAF(ctx context.Context, db *sql.DB, config *OafConfig, opts ...Option) (err error) {
+	o := newOptions(opts)
+	start := time.Now()
+	o.report.begin(ctx, "oaf", db)
+	defer o.report.finish(start, &err)
+
+	units, duplicates, err := oafUnits(ctx, db, config, o)
+	if err != nil {
+		return err
+	}
+	run, err := startRun(ctx, db, "oaf", config, start)
+	if err != nil {
+		return err
+	}
+	err = transactional(ctx, db, o, units, func(tx dbtx) error {
+		return run.record(ctx, tx)
+	})
+	if config != nil && config.ExternalFidReport != "" {
+		// also written on failure, as the report shows which rows caused it
+		if reportErr := writeDuplicateExternalFids(config.ExternalFidReport, *duplicates); reportErr != nil && err == nil {
+			err = reportErr
+		}
+	}
+	return err
+}
+
+// owsUnits returns the units of work for the OWS optimizations, together with the puuids of the
+// previous release that no longer exist, which is filled when the units are applied
+func owsUnits(ctx context.Context, db *sql.DB, config *OwsConfig, o *options) ([]unitOfWork, *[]RemovedPUUID, error) {
+	if err := checkRequirements(ctx, db, owsRequirements, o); err != nil {
+		return nil, nil, err
+	}
+	var puuidConfig *PUUIDConfig
+	if config != nil {
+		if err := config.validate(); err != nil {
+			return nil, nil, err
+		}
+		puuidConfig = config.PUUID
+	}
+
+	tables, err := getTables(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	removed := &[]RemovedPUUID{}
+
+	var units []unitOfWork
+	for _, table := range tables {
+		// features and attributes can be served by OWS, tile pyramids are left as-is
+		if table.DataType != dataTypeFeatures && table.DataType != dataTypeAttributes {
+			slog.Info("skipping OWS optimizations", "table", table.Name, "dataType", table.DataType)
+			continue
+		}
+		units = append(units, unitOfWork{table.Name, func(db dbtx) error {
+			generator, err := newPUUIDGenerator(table.Name, puuidConfig, o.report)
+			if err != nil {
+				return err
+			}
+			if err = addOWSDefaultOptimizations(ctx, table.Name, generator, db); err != nil {
+				return err
+			}
+			*removed = append(*removed, generator.removed()...)
+			return nil
+		}})
+	}
+	if config != nil {
+		for _, index := range config.Indices {
+			units = append(units, unitOfWork{index.Table, func(db dbtx) error {
+				return createIndex(ctx, index.Table, index.Columns, index.Name, index.Unique, db)
+			}})
+		}
+	}
+	return units, removed, nil
+}
+
+// oafUnits returns the units of work for the OAF optimizations, together with the rows sharing an
+// external_fid, which is filled when the units are applied
+func oafUnits(ctx context.Context, db *sql.DB, config *OafConfig, o *options) ([]unitOfWork, *[]DuplicateExternalFid, error) {
+	if err := checkRequirements(ctx, db, oafRequirements, o); err != nil {
+		return nil, nil, err
+	}
+	tables, err := getTables(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config != nil {
+		if err = config.setDefaults(); err != nil {
+			return nil, nil, err
+		}
+		if err = config.validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	duplicates := &[]DuplicateExternalFid{}
+
+	var units []unitOfWork
+	for _, table := range tables {
+		// OGC API Features serves features and attributes (features without geometry), tile pyramids are left as-is
+		if table.DataType != dataTypeFeatures && table.DataType != dataTypeAttributes {
+			slog.Info("skipping OAF optimizations", "table", table.Name, "dataType", table.DataType)
+			continue
+		}
+
+		if config == nil {
+			if table.DataType != dataTypeFeatures {
+				continue // nothing to optimize by default for tables without geometry
+			}
+			units = append(units, unitOfWork{table.Name, func(db dbtx) error {
+				fidColumn, geomColumn, err := resolveColumns(ctx, table, "", "", db)
+				if err != nil {
+					return err
+				}
+				if err = addOAFDefaultOptimizations(ctx, table.Name, fidColumn, geomColumn, nil, db); err != nil {
+					return err
+				}
+				return analyze(ctx, table.Name, db)
+			}})
+			continue
+		}
+
+		layerCfg, ok := config.Layers[table.Name]
+		if !ok {
+			o.report.warn(table.Name, "no config found for gpkg table '%s'", table.Name)
+			continue
+		}
+		units = append(units, unitOfWork{table.Name, func(db dbtx) error {
+			if err := optimizeOAFLayer(ctx, table, layerCfg, duplicates, db); err != nil {
+				return err
+			}
+			return analyze(ctx, table.Name, db)
+		}})
+	}
+	return units, duplicates, nil
+}
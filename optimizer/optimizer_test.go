@@ -0,0 +1,1696 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestOptimizeOWSGeopackage(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_ows.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	err = OptimizeOWSFile(context.Background(), sourceGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	tables, err := getTables(context.Background(), db)
+	if err != nil {
+		t.Fatalf("error getting tables: %s", err)
+	}
+
+	for _, table := range tables {
+		tableName := table.Name
+		query := fmt.Sprintf("select puuid, fuuid from '%v'", tableName)
+
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("error executing query: %s", err)
+		}
+
+		for rows.Next() {
+			var puuid string
+			var fuuid string
+			err = rows.Scan(&puuid, &fuuid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err := uuid.Parse(puuid)
+			if err != nil {
+				t.Fatalf("Generated uuid is invalid because: '%s'", err)
+			}
+			if fuuid != fmt.Sprintf("%s.%s", tableName, puuid) {
+				t.Fatalf("Generated fuuid is invalid because it doesnt match pattern 'tableName.puuid': '%s'", fuuid)
+			}
+		}
+	}
+}
+
+func TestOptimizeOAFGeopackageNoConfig(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check spatial columns
+	rows, err := db.Query("select minx, maxx, miny, maxy from 'pand';")
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	for rows.Next() {
+		var minx, maxx, miny, maxy string
+		err = rows.Scan(&minx, &maxx, &miny, &maxy)
+		if err != nil {
+			t.Fatalf("error scanning row: %s", err)
+		}
+	}
+
+	// check spatial index
+	rows, err = db.Query("select exists(select 1 from sqlite_master where type = 'index' and name = 'pand_spatial_idx' and tbl_name = 'pand') as index_exists;")
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	for rows.Next() {
+		var exists int
+		err = rows.Scan(&exists)
+		if err != nil {
+			t.Fatalf("error scanning row: %s", err)
+		}
+		if exists != 1 {
+			t.Fatal("spatial index missing for table 'pand'")
+		}
+	}
+}
+
+func TestOptimizeOAFGeopackageExternalFid(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "external-fid-columns":
+	      [
+	        "identificatie"
+	      ]
+	    }
+	  }
+	}`
+	oafConfig, err := ParseOafConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, oafConfig)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select external_fid from 'pand';")
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	for rows.Next() {
+		var externalFid string
+		err = rows.Scan(&externalFid)
+		if err != nil {
+			t.Fatalf("error scanning row: %s", err)
+		}
+		_, err := uuid.Parse(externalFid)
+		if err != nil {
+			t.Fatalf("'external_fid' is invalid because: '%s'", err)
+		}
+	}
+}
+
+func TestOptimizeOAFGeopackageSQLStatements(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "sql-statements":
+	      [
+	        "ALTER TABLE pand ADD COLUMN fid_copy integer",
+	        "UPDATE pand SET fid_copy = fid",
+	        "CREATE INDEX pand_identificatie_idx ON pand(identificatie)"
+	      ]
+	    }
+	  }
+	}`
+	oafConfig, err := ParseOafConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, oafConfig)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check copied column
+	rows, err := db.Query("select fid, fid_copy from 'pand';")
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	for rows.Next() {
+		var fid, fidCopy string
+		err = rows.Scan(&fid, &fidCopy)
+		if err != nil {
+			t.Fatalf("error scanning row: %s", err)
+		}
+		if fid != fidCopy {
+			t.Fatalf("row invalid: '%s' != '%s'", fid, fidCopy)
+		}
+	}
+
+	// check specified index
+	rows, err = db.Query("select exists(select 1 from sqlite_master where type = 'index' and name = 'pand_identificatie_idx' and tbl_name = 'pand') as index_exists;")
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	for rows.Next() {
+		var exists int
+		err = rows.Scan(&exists)
+		if err != nil {
+			t.Fatalf("error scanning row: %s", err)
+		}
+		if exists != 1 {
+			t.Fatal("index 'pand_identificatie_idx' is missing")
+		}
+	}
+}
+
+func TestOptimizeOAFGeopackageFullConfig(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "fid-column": "feature_id",
+	      "geom-column": "geom",
+	      "sql-statements":
+	      [
+	        "ALTER TABLE pand RENAME COLUMN fid TO feature_id",
+	        "CREATE INDEX pand_identificatie_idx ON pand(identificatie)"
+	      ],
+	      "external-fid-columns":
+	      [
+	        "identificatie"
+	      ],
+	      "temporal-columns":
+	      [
+	        "bouwjaar"
+	      ]
+	    }
+	  }
+	}`
+	oafConfig, err := ParseOafConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, oafConfig)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check temporal index exists
+	rows, err := db.Query("select exists(select 1 from sqlite_master where type = 'index' and name = 'pand_temporal_idx' and tbl_name = 'pand') as index_exists;")
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	for rows.Next() {
+		var exists int
+		err = rows.Scan(&exists)
+		if err != nil {
+			t.Fatalf("error scanning row: %s", err)
+		}
+		if exists != 1 {
+			t.Fatal("index 'pand_temporal_idx' is missing")
+		}
+	}
+}
+
+func TestOptimizeOWSGeopackageStructuredError(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_ows.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := &OwsConfig{
+		Indices: []ManualIndex{
+			{Name: "missing_idx", Table: "missing", Columns: []string{"name"}},
+		},
+	}
+	err = OptimizeOWSFile(context.Background(), sourceGeopackage, config)
+
+	var optimizerErr *Error
+	if !errors.As(err, &optimizerErr) {
+		t.Fatalf("expected optimizer error, got: '%v'", err)
+	}
+	if optimizerErr.Step != StepCreateIndex || optimizerErr.Table != "missing" {
+		t.Fatalf("unexpected step or table in error: '%s'", optimizerErr)
+	}
+}
+
+func TestOptimizeOWSGeopackageRerun(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_ows.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	err = OptimizeOWSFile(context.Background(), sourceGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	var puuidBefore string
+	err = db.QueryRow("select puuid from 'layer' where fid = 1").Scan(&puuidBefore)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	err = OptimizeOWSFile(context.Background(), sourceGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error re-optimizing GeoPackage: %s", err)
+	}
+
+	var puuidAfter string
+	err = db.QueryRow("select puuid from 'layer' where fid = 1").Scan(&puuidAfter)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if puuidBefore != puuidAfter {
+		t.Fatalf("puuid changed on re-run: '%s' != '%s'", puuidBefore, puuidAfter)
+	}
+}
+
+func TestOptimizeOAFGeopackageRerunRebuildsIndex(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "external-fid-columns":
+	      [
+	        "identificatie"
+	      ],
+	      "temporal-columns":
+	      [
+	        "bouwjaar"
+	      ]
+	    }
+	  }
+	}`
+	oafConfig, err := ParseOafConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	// the first rerun rebuilds the spatial index with the temporal column, the second finds it up to date
+	var rebuilt [2]bool
+	for i := 0; i < 2; i++ {
+		var report Report
+		err = OptimizeOAFFile(context.Background(), sourceGeopackage, oafConfig, WithReport(&report))
+		if err != nil {
+			t.Fatalf("error re-optimizing GeoPackage: %s", err)
+		}
+		for _, table := range report.Tables {
+			for _, index := range table.IndexesCreated {
+				if index.Name == "pand_spatial_idx" {
+					rebuilt[i] = true
+				}
+			}
+		}
+	}
+	if !rebuilt[0] || rebuilt[1] {
+		t.Fatalf("expected the spatial index to be rebuilt on the first rerun only, got: %v", rebuilt)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check spatial index now includes the temporal column
+	var columns string
+	err = db.QueryRow("select group_concat(name, ',') from pragma_index_info('pand_spatial_idx');").Scan(&columns)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if columns != "fid,minx,maxx,miny,maxy,bouwjaar" {
+		t.Fatalf("spatial index not rebuilt, columns: '%s'", columns)
+	}
+}
+
+func TestOptimizeOWSGeopackageOutput(t *testing.T) {
+	sourceGeopackage := "../geopackage/original_ows.gpkg"
+	outputGeopackage := "../geopackage/geopackage.gpkg"
+	os.Remove(outputGeopackage)
+
+	err := OptimizeOWSFile(context.Background(), sourceGeopackage, nil, WithOutput(outputGeopackage))
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	source, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer source.Close()
+	exists, err := columnExists(context.Background(), "layer", "puuid", source)
+	if err != nil {
+		t.Fatalf("error inspecting source GeoPackage: %s", err)
+	}
+	if exists {
+		t.Fatal("source GeoPackage was modified")
+	}
+
+	output, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening output GeoPackage: %s", err)
+	}
+	defer output.Close()
+	exists, err = columnExists(context.Background(), "layer", "puuid", output)
+	if err != nil {
+		t.Fatalf("error inspecting output GeoPackage: %s", err)
+	}
+	if !exists {
+		t.Fatal("output GeoPackage is not optimized")
+	}
+}
+
+func TestOptimizeOWSGeopackageOutputOnFailure(t *testing.T) {
+	sourceGeopackage := "../geopackage/original_ows.gpkg"
+	outputGeopackage := "../geopackage/geopackage.gpkg"
+	os.Remove(outputGeopackage)
+
+	config := &OwsConfig{
+		Indices: []ManualIndex{
+			{Name: "missing_idx", Table: "missing", Columns: []string{"name"}},
+		},
+	}
+	err := OptimizeOWSFile(context.Background(), sourceGeopackage, config, WithOutput(outputGeopackage))
+	if err == nil {
+		t.Fatal("expected error optimizing GeoPackage")
+	}
+	if _, err = os.Stat(outputGeopackage); !os.IsNotExist(err) {
+		t.Fatal("output GeoPackage should not exist after a failed run")
+	}
+	leftovers, _ := filepath.Glob("../geopackage/.geopackage.gpkg.*.tmp")
+	if len(leftovers) > 0 {
+		t.Fatalf("temporary files were left behind: %v", leftovers)
+	}
+}
+
+func TestOptimizeOWSGeopackageRollback(t *testing.T) {
+	for _, tc := range []struct {
+		scope         TransactionScope
+		expectedPUUID bool
+	}{
+		{TransactionScopeRun, false},
+		{TransactionScopeTable, true},
+	} {
+		sourceGeopackage := "../geopackage/geopackage.gpkg"
+		source, err := os.Open("../geopackage/original_ows.gpkg")
+		if err != nil {
+			t.Fatalf("error opening source GeoPackage: %s", err)
+		}
+
+		destination, _ := os.Create(sourceGeopackage)
+		_, err = io.Copy(destination, source)
+		if err != nil {
+			t.Fatalf("error copying GeoPackage: %s", err)
+		}
+
+		config := &OwsConfig{
+			Indices: []ManualIndex{
+				{Name: "missing_idx", Table: "missing", Columns: []string{"name"}},
+			},
+		}
+		err = OptimizeOWSFile(context.Background(), sourceGeopackage, config, WithTransactionScope(tc.scope))
+		if err == nil {
+			t.Fatal("expected error optimizing GeoPackage")
+		}
+
+		db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+		if err != nil {
+			t.Fatalf("error opening sourceGeoPackage: %s", err)
+		}
+		exists, err := columnExists(context.Background(), "layer", "puuid", db)
+		if err != nil {
+			t.Fatalf("error inspecting GeoPackage: %s", err)
+		}
+		if exists != tc.expectedPUUID {
+			t.Fatalf("transaction scope '%s': expected puuid column present to be %t", tc.scope, tc.expectedPUUID)
+		}
+		db.Close()
+	}
+}
+
+func TestOptimizeOAFGeopackageRelations(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := `{
+	  "layers":
+	  {
+	    "pand":
+	    {
+	      "sql-statements":
+	      [
+	        "CREATE TABLE IF NOT EXISTS bouwjaar (id INTEGER PRIMARY KEY, jaar DATE, omschrijving TEXT)",
+	        "DELETE FROM bouwjaar",
+	        "INSERT INTO bouwjaar (jaar, omschrijving) SELECT DISTINCT bouwjaar, 'bouwjaar ' || bouwjaar FROM pand"
+	      ],
+	      "relations":
+	      [
+	        {
+	          "table": "bouwjaar",
+	          "columns": { "fk": "bouwjaar", "pk": "jaar" }
+	        }
+	      ]
+	    }
+	  }
+	}`
+	oafConfig, err := ParseOafConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, oafConfig)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// check indexes on both sides of the relation
+	for _, indexName := range []string{"pand_bouwjaar_fk_idx", "bouwjaar_jaar_idx"} {
+		index, err := getIndex(context.Background(), indexName, db)
+		if err != nil {
+			t.Fatalf("error inspecting index: %s", err)
+		}
+		if index == nil {
+			t.Fatalf("index '%s' is missing", indexName)
+		}
+	}
+
+	// check registration in related tables extension
+	var relationName, mappingTable string
+	err = db.QueryRow("select relation_name, mapping_table_name from gpkgext_relations where base_table_name = 'pand' and related_table_name = 'bouwjaar';").Scan(&relationName, &mappingTable)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if relationName != "attributes" || mappingTable != "pand_bouwjaar" {
+		t.Fatalf("unexpected relation registered: '%s' using '%s'", relationName, mappingTable)
+	}
+
+	var mappings int
+	err = db.QueryRow("select count(*) from pand_bouwjaar;").Scan(&mappings)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if mappings != 4 {
+		t.Fatalf("expected 4 mappings in 'pand_bouwjaar', got %d", mappings)
+	}
+
+	// check registration in gpkg_extensions, also after a rerun
+	db.Close()
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, oafConfig)
+	if err != nil {
+		t.Fatalf("error rerunning optimization: %s", err)
+	}
+	db, err = OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+	for _, table := range []string{"gpkgext_relations", "pand_bouwjaar"} {
+		var registered int
+		err = db.QueryRow("select count(*) from gpkg_extensions where table_name = ? and extension_name = ?;", table, relatedTablesExtension).Scan(&registered)
+		if err != nil {
+			t.Fatalf("error executing query: %s", err)
+		}
+		if registered != 1 {
+			t.Fatalf("expected table '%s' to be registered once in gpkg_extensions, got %d", table, registered)
+		}
+	}
+	var relations int
+	if err = db.QueryRow("select count(*) from gpkgext_relations;").Scan(&relations); err != nil || relations != 1 {
+		t.Fatalf("expected 1 relation after a rerun, got %d (%v)", relations, err)
+	}
+}
+
+func TestOptimizeOAFGeopackageRelationMissingTable(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config := &OafConfig{
+		Layers: map[string]Layer{
+			"pand": {
+				Relations: []Relation{
+					{Table: "missing", Columns: RelationColumns{ForeignKey: "identificatie", PrimaryKey: "id"}},
+				},
+			},
+		},
+	}
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, config)
+
+	var optimizerErr *Error
+	if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepCreateRelation {
+		t.Fatalf("expected create relation error, got: '%v'", err)
+	}
+}
+
+func TestOptimizeOAFGeopackageRelationMappingTableCollision(t *testing.T) {
+	sourceGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	b, err := os.ReadFile("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error reading source GeoPackage: %s", err)
+	}
+	if err = os.WriteFile(sourceGeopackage, b, 0644); err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	// a table of the GeoPackage itself with the name of the default mapping table
+	_, err = db.Exec(`CREATE TABLE bouwjaar (id INTEGER PRIMARY KEY, jaar DATE);
+		INSERT INTO bouwjaar (jaar) SELECT DISTINCT bouwjaar FROM pand;
+		CREATE TABLE pand_bouwjaar (note TEXT);
+		INSERT INTO pand_bouwjaar VALUES ('keep me')`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	relation := Relation{Table: "bouwjaar", Columns: RelationColumns{ForeignKey: "bouwjaar", PrimaryKey: "jaar"}}
+	config := &OafConfig{Layers: map[string]Layer{"pand": {Relations: []Relation{relation}}}}
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, config)
+	var optimizerErr *Error
+	if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepCreateRelation || !strings.Contains(err.Error(), "mapping table 'pand_bouwjaar' already exists") {
+		t.Fatalf("expected an error for the existing mapping table, got: '%v'", err)
+	}
+
+	// a configured mapping table leaves the existing table alone, also on a rerun
+	relation.MappingTable = "pand_bouwjaar_mapping"
+	config = &OafConfig{Layers: map[string]Layer{"pand": {Relations: []Relation{relation}}}}
+	for i := 0; i < 2; i++ {
+		if err = OptimizeOAFFile(context.Background(), sourceGeopackage, config); err != nil {
+			t.Fatalf("error optimizing GeoPackage: %s", err)
+		}
+	}
+	db, err = OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+	var note, mappingTable string
+	var mappings int
+	err = db.QueryRow(`SELECT (SELECT note FROM pand_bouwjaar), (SELECT mapping_table_name FROM gpkgext_relations), (SELECT count(*) FROM pand_bouwjaar_mapping)`).Scan(&note, &mappingTable, &mappings)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if note != "keep me" || mappingTable != "pand_bouwjaar_mapping" || mappings != 4 {
+		t.Fatalf("unexpected tables after optimizing: '%s', '%s' with %d mappings", note, mappingTable, mappings)
+	}
+}
+
+func TestPlanOWSGeopackage(t *testing.T) {
+	sourceGeopackage := "../geopackage/original_ows.gpkg"
+	before, err := os.ReadFile(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error reading source GeoPackage: %s", err)
+	}
+
+	plan, err := PlanOWSFile(context.Background(), sourceGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error planning optimizations: %s", err)
+	}
+
+	after, err := os.ReadFile(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error reading source GeoPackage: %s", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("source GeoPackage was modified by plan")
+	}
+
+	if len(plan.Tables) == 0 || plan.Tables[0].Table != "layer" || plan.Tables[0].EstimatedRows != 4 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	statements := plan.Tables[0].Statements
+	if len(statements) != 6 || statements[0].SQL != "ALTER TABLE \"layer\" ADD \"puuid\" TEXT;" || statements[1].Rows != 4 {
+		t.Fatalf("unexpected planned statements: %+v", statements)
+	}
+
+	missingGeopackage := filepath.Join(t.TempDir(), "missing.gpkg")
+	for _, planFile := range []func() (*Plan, error){
+		func() (*Plan, error) { return PlanOWSFile(context.Background(), missingGeopackage, nil) },
+		func() (*Plan, error) { return PlanOAFFile(context.Background(), missingGeopackage, nil) },
+	} {
+		_, err = planFile()
+		var optimizerErr *Error
+		if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepOpen {
+			t.Fatalf("expected an open error for a missing GeoPackage, got: '%v'", err)
+		}
+		if _, err = os.Stat(missingGeopackage); !os.IsNotExist(err) {
+			t.Fatalf("expected plan not to create the missing GeoPackage, got: %v", err)
+		}
+	}
+}
+
+func TestOptimizeGeopackageDataTypes(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_ows.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// add a tile pyramid, next to the 'layer' features and 'layer_styles' attributes
+	_, err = db.Exec(`CREATE TABLE tiles (id INTEGER PRIMARY KEY AUTOINCREMENT, zoom_level INTEGER NOT NULL, tile_column INTEGER NOT NULL, tile_row INTEGER NOT NULL, tile_data BLOB NOT NULL);
+		INSERT INTO gpkg_contents (table_name, data_type, identifier, srs_id) VALUES ('tiles', 'tiles', 'tiles', 28992);`)
+	if err != nil {
+		t.Fatalf("error adding tiles table: %s", err)
+	}
+
+	err = OptimizeOAF(context.Background(), db, nil)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	err = OptimizeOWS(context.Background(), db, nil)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	for _, tc := range []struct {
+		table    string
+		column   string
+		expected bool
+	}{
+		{"layer", "minx", true},
+		{"layer", "puuid", true},
+		{"layer_styles", "minx", false},
+		{"layer_styles", "puuid", true},
+		{"tiles", "minx", false},
+		{"tiles", "puuid", false},
+	} {
+		exists, err := columnExists(context.Background(), tc.table, tc.column, db)
+		if err != nil {
+			t.Fatalf("error inspecting GeoPackage: %s", err)
+		}
+		if exists != tc.expected {
+			t.Fatalf("expected column '%s' in table '%s' to exist: %t", tc.column, tc.table, tc.expected)
+		}
+	}
+}
+
+func TestOptimizeOAFGeopackageDetectColumns(t *testing.T) {
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_ows.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening sourceGeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// feature table as exported by GDAL with non-default column names
+	_, err = db.Exec(`CREATE TABLE gdal (ogc_fid INTEGER PRIMARY KEY AUTOINCREMENT, the_geom POINT, name TEXT);
+		INSERT INTO gdal (the_geom, name) SELECT geom, name FROM layer;
+		INSERT INTO gpkg_contents (table_name, data_type, identifier, srs_id) VALUES ('gdal', 'features', 'gdal', 28992);
+		INSERT INTO gpkg_geometry_columns VALUES ('gdal', 'the_geom', 'POINT', 28992, 0, 0);`)
+	if err != nil {
+		t.Fatalf("error adding feature table: %s", err)
+	}
+
+	// configured geometry column conflicts with the registered one
+	config := &OafConfig{Layers: map[string]Layer{"gdal": {GeomColumn: "geom"}}}
+	err = OptimizeOAF(context.Background(), db, config)
+	var optimizerErr *Error
+	if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepResolveColumns || optimizerErr.Table != "gdal" {
+		t.Fatalf("expected resolve columns error, got: '%v'", err)
+	}
+
+	// configured fid column conflicts with the integer primary key
+	config = &OafConfig{Layers: map[string]Layer{"gdal": {FidColumn: "name"}}}
+	err = OptimizeOAF(context.Background(), db, config)
+	if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepResolveColumns || optimizerErr.Table != "gdal" || optimizerErr.Column != "name" ||
+		!strings.Contains(err.Error(), "'ogc_fid'") {
+		t.Fatalf("expected resolve columns error naming both columns, got: '%v'", err)
+	}
+
+	err = OptimizeOAF(context.Background(), db, nil)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	index, err := getIndex(context.Background(), "gdal_spatial_idx", db)
+	if err != nil {
+		t.Fatalf("error inspecting index: %s", err)
+	}
+	if index == nil || !index.matches("gdal", []string{"ogc_fid", "minx", "maxx", "miny", "maxy"}, false) {
+		t.Fatalf("unexpected spatial index: %+v", index)
+	}
+
+	var nulls int
+	err = db.QueryRow("select count(*) from gdal where minx is null").Scan(&nulls)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if nulls > 0 {
+		t.Fatalf("%d rows without envelope", nulls)
+	}
+}
+
+func TestOptimizeOWSGeopackageDeterministicPUUID(t *testing.T) {
+	t.Setenv("PUUID_SECRET", "s3cr3t")
+	config, err := ParseOwsConfig([]byte(`{"puuid":{"mode":"deterministic","key-columns":{"layer":["name"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+
+	puuids := make([]string, 2)
+	for i := range puuids {
+		outputGeopackage := "../geopackage/geopackage.gpkg"
+		os.Remove(outputGeopackage)
+		err = OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", config, WithOutput(outputGeopackage))
+		if err != nil {
+			t.Fatalf("error optimizing GeoPackage: %s", err)
+		}
+
+		db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+		if err != nil {
+			t.Fatalf("error opening GeoPackage: %s", err)
+		}
+		err = db.QueryRow("select puuid from 'layer' where name = 'feature_0'").Scan(&puuids[i])
+		db.Close()
+		if err != nil {
+			t.Fatalf("error executing query: %s", err)
+		}
+	}
+	if puuids[0] != puuids[1] {
+		t.Fatalf("deterministic puuid differs between releases: '%s' != '%s'", puuids[0], puuids[1])
+	}
+
+	t.Setenv("PUUID_SECRET", "another secret")
+	db, err := OpenDB("../geopackage/geopackage.gpkg")
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("update 'layer' set puuid = null"); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if err = OptimizeOWS(context.Background(), db, config); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	var puuid string
+	if err = db.QueryRow("select puuid from 'layer' where name = 'feature_0'").Scan(&puuid); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if puuid == puuids[0] {
+		t.Fatal("deterministic puuid doesn't depend on the secret")
+	}
+}
+
+func TestOptimizeOWSGeopackagePreviousReleasePUUID(t *testing.T) {
+	previousGeopackage := filepath.Join(t.TempDir(), "previous.gpkg")
+	err := OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", nil, WithOutput(previousGeopackage))
+	if err != nil {
+		t.Fatalf("error optimizing previous GeoPackage: %s", err)
+	}
+	previous, err := sql.Open("sqlite3_with_extensions", previousGeopackage)
+	if err != nil {
+		t.Fatalf("error opening previous GeoPackage: %s", err)
+	}
+	defer previous.Close()
+	var carriedPUUID, removedPUUID string
+	if err = previous.QueryRow("select puuid from 'layer' where name = 'feature_0'").Scan(&carriedPUUID); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if err = previous.QueryRow("select puuid from 'layer' where name = 'feature_3'").Scan(&removedPUUID); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	sourceGeopackage := "../geopackage/geopackage.gpkg"
+	source, err := os.Open("../geopackage/original_ows.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("delete from 'layer' where name = 'feature_3'; insert into 'layer' (name) values ('feature_4')"); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	removedReport := filepath.Join(t.TempDir(), "removed.json")
+	config, err := ParseOwsConfig([]byte(fmt.Sprintf(`{"puuid":{"mode":"previous-release","previous-geopackage":"%s","removed-report":"%s","key-columns":{"layer":["name"]}}}`,
+		previousGeopackage, removedReport)))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	if err = OptimizeOWS(context.Background(), db, config); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	var puuid string
+	if err = db.QueryRow("select puuid from 'layer' where name = 'feature_0'").Scan(&puuid); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if puuid != carriedPUUID {
+		t.Fatalf("puuid was not carried over from previous release: '%s' != '%s'", puuid, carriedPUUID)
+	}
+	if err = db.QueryRow("select puuid from 'layer' where name = 'feature_4'").Scan(&puuid); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if _, err = uuid.Parse(puuid); err != nil {
+		t.Fatalf("new row didn't get a valid puuid: '%s'", puuid)
+	}
+
+	report, err := os.ReadFile(removedReport)
+	if err != nil {
+		t.Fatalf("error reading removed report: %s", err)
+	}
+	var removed []RemovedPUUID
+	if err = json.Unmarshal(report, &removed); err != nil {
+		t.Fatalf("error parsing removed report: %s", err)
+	}
+	if len(removed) != 1 || removed[0].Table != "layer" || removed[0].PUUID != removedPUUID {
+		t.Fatalf("unexpected removed puuids: %s", report)
+	}
+}
+
+func TestOptimizeGeopackageWorkers(t *testing.T) {
+	config, err := ParseOafConfig([]byte(`{"layers":{"layer":{},"pand":{"external-fid-columns":["identificatie"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+
+	query := "select external_fid, minx, maxx, miny, maxy from 'pand' order by fid"
+	results := make([][]string, 2)
+	for i, workers := range []int{1, 4} {
+		outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+		err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(outputGeopackage), WithWorkers(workers))
+		if err != nil {
+			t.Fatalf("error optimizing GeoPackage with %d workers: %s", workers, err)
+		}
+
+		db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+		if err != nil {
+			t.Fatalf("error opening GeoPackage: %s", err)
+		}
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("error executing query: %s", err)
+		}
+		for rows.Next() {
+			var externalFid string
+			var minx, maxx, miny, maxy sql.NullFloat64
+			if err = rows.Scan(&externalFid, &minx, &maxx, &miny, &maxy); err != nil {
+				t.Fatalf("error scanning row: %s", err)
+			}
+			results[i] = append(results[i], fmt.Sprintf("%s %v %v %v %v", externalFid, minx, maxx, miny, maxy))
+		}
+		rows.Close()
+		db.Close()
+	}
+	if len(results[0]) == 0 || fmt.Sprint(results[0]) != fmt.Sprint(results[1]) {
+		t.Fatalf("results differ between 1 and 4 workers: %v != %v", results[0], results[1])
+	}
+
+	// deterministic puuids, so the results of both runs can be compared
+	t.Setenv("PUUID_SECRET", "s3cr3t")
+	owsConfig, err := ParseOwsConfig([]byte(`{"puuid":{"mode":"deterministic","key-columns":{"layer":["name"],"layer_styles":["styleName"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	results = make([][]string, 2)
+	for i, workers := range []int{1, 4} {
+		outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+		err = OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", owsConfig, WithOutput(outputGeopackage), WithWorkers(workers))
+		if err != nil {
+			t.Fatalf("error optimizing GeoPackage with %d workers: %s", workers, err)
+		}
+
+		db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+		if err != nil {
+			t.Fatalf("error opening GeoPackage: %s", err)
+		}
+		for _, table := range []string{"layer", "layer_styles"} {
+			rows, err := db.Query(fmt.Sprintf("select puuid, fuuid from '%s' order by rowid", table))
+			if err != nil {
+				t.Fatalf("error executing query: %s", err)
+			}
+			for rows.Next() {
+				var puuid, fuuid sql.NullString
+				if err = rows.Scan(&puuid, &fuuid); err != nil {
+					t.Fatalf("error scanning row: %s", err)
+				}
+				if !puuid.Valid || !fuuid.Valid {
+					t.Fatalf("row without puuid in table '%s' with %d workers", table, workers)
+				}
+				results[i] = append(results[i], fuuid.String)
+			}
+			rows.Close()
+		}
+		db.Close()
+	}
+	if len(results[0]) == 0 || fmt.Sprint(results[0]) != fmt.Sprint(results[1]) {
+		t.Fatalf("OWS results differ between 1 and 4 workers: %v != %v", results[0], results[1])
+	}
+}
+
+func TestOptimizeOAFGeopackageChunks(t *testing.T) {
+	config, err := ParseOafConfig([]byte(`{"layers":{"pand":{"external-fid-columns":["identificatie"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	query := "select group_concat(fid || ':' || coalesce(external_fid, '') || ':' || coalesce(minx, ''), ',') from (select * from 'pand' order by fid)"
+
+	expectedGeopackage := filepath.Join(t.TempDir(), "expected.gpkg")
+	if err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(expectedGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	expectedDB, err := sql.Open("sqlite3_with_extensions", expectedGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer expectedDB.Close()
+	var expected string
+	if err = expectedDB.QueryRow(query).Scan(&expected); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	for name, opts := range map[string][]Option{
+		"chunk":       {WithTransactionScope(TransactionScopeChunk), WithChunkSize(3)},
+		"single pass": {WithSinglePass()},
+	} {
+		outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+		err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, append(opts, WithOutput(outputGeopackage))...)
+		if err != nil {
+			t.Fatalf("error optimizing GeoPackage (%s): %s", name, err)
+		}
+		db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+		if err != nil {
+			t.Fatalf("error opening GeoPackage: %s", err)
+		}
+		var actual string
+		err = db.QueryRow(query).Scan(&actual)
+		if err != nil {
+			t.Fatalf("error executing query: %s", err)
+		}
+		if actual != expected {
+			t.Fatalf("result (%s) differs: '%s' != '%s'", name, actual, expected)
+		}
+		exists, err := tableExists(context.Background(), progressTable, db)
+		db.Close()
+		if err != nil {
+			t.Fatalf("error inspecting GeoPackage: %s", err)
+		}
+		if exists {
+			t.Fatalf("progress table wasn't dropped after finishing (%s)", name)
+		}
+	}
+
+	var externalFid string
+	if err = expectedDB.QueryRow("select pdok_external_fid('pand', identificatie) = external_fid from 'pand' where fid = 1").Scan(&externalFid); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if externalFid != "1" {
+		t.Fatal("pdok_external_fid differs from the generated external_fid")
+	}
+}
+
+func TestOptimizeOAFGeopackageResume(t *testing.T) {
+	sourceGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	source, err := os.Open("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error opening source GeoPackage: %s", err)
+	}
+	destination, _ := os.Create(sourceGeopackage)
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+
+	config, err := ParseOafConfig([]byte(`{"layers":{"pand":{"external-fid-columns":["identificatie"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	// simulate a run that was interrupted after committing the chunk up to fid 2
+	_, err = db.Exec(`alter table 'pand' add external_fid TEXT;
+		create table pdok_optimizer_progress (table_name TEXT NOT NULL, step TEXT NOT NULL, last_rowid INTEGER NOT NULL, PRIMARY KEY (table_name, step));
+		insert into pdok_optimizer_progress values ('pand', 'external_fid', 2);`)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	err = OptimizeOAF(context.Background(), db, config, WithTransactionScope(TransactionScopeChunk), WithChunkSize(1))
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	var skipped, filled int
+	if err = db.QueryRow("select count(*) filter (where external_fid is null), count(*) filter (where external_fid is not null) from 'pand'").Scan(&skipped, &filled); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if skipped != 2 || filled != 2 {
+		t.Fatalf("expected the update to resume after fid 2, got %d rows skipped and %d filled", skipped, filled)
+	}
+	var resumed string
+	if err = db.QueryRow("select group_concat(fid, ',') from 'pand' where external_fid = pdok_external_fid('pand', identificatie)").Scan(&resumed); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if resumed != "3,4" {
+		t.Fatalf("expected the external_fid of fid 3 and 4 to be generated, got: '%s'", resumed)
+	}
+	exists, err := tableExists(context.Background(), progressTable, db)
+	if err != nil {
+		t.Fatalf("error inspecting GeoPackage: %s", err)
+	}
+	if exists {
+		t.Fatal("progress table wasn't dropped after finishing")
+	}
+}
+
+func TestOptimizeOWSGeopackageSinglePass(t *testing.T) {
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	err := OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", nil, WithOutput(outputGeopackage), WithSinglePass())
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+	var rows, distinct int
+	var puuid string
+	if err = db.QueryRow("select count(*), count(distinct puuid), max(puuid) from 'layer'").Scan(&rows, &distinct, &puuid); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if rows == 0 || rows != distinct {
+		t.Fatalf("expected a unique puuid for each of the %d rows, got %d", rows, distinct)
+	}
+	if _, err = uuid.Parse(puuid); err != nil {
+		t.Fatalf("generated uuid is invalid: '%s'", puuid)
+	}
+}
+
+func TestOptimizeOAFGeopackageExternalFidAlgorithm(t *testing.T) {
+	namespace := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+	config, err := ParseOafConfig([]byte(fmt.Sprintf(`{"layers":{"pand":{"external-fid-columns":["identificatie","bouwjaar"],"external-fid-namespace":"%s","external-fid-algorithm":"canonical-v1"}}}`, namespace)))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(outputGeopackage))
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+
+	var externalFid string
+	if err = db.QueryRow("select external_fid from 'pand' where fid = 1").Scan(&externalFid); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if expected := uuid.NewSHA1(uuid.MustParse(namespace), []byte("pand|NL.IMBAG.Pand.1|1951-01-01")).String(); externalFid != expected {
+		t.Fatalf("expected external_fid '%s', got '%s'", expected, externalFid)
+	}
+
+	var algorithm, registeredNamespace, columns string
+	err = db.QueryRow("select algorithm, namespace, columns from pdok_external_fid_metadata where table_name = 'pand'").Scan(&algorithm, &registeredNamespace, &columns)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if algorithm != ExternalFidAlgorithmCanonicalV1 || registeredNamespace != namespace || columns != "identificatie,bouwjaar" {
+		t.Fatalf("unexpected external_fid metadata: %s %s %s", algorithm, registeredNamespace, columns)
+	}
+
+	if _, err = ParseOafConfig([]byte(`{"layers":{"pand":{"external-fid-namespace":"not-a-uuid"}}}`)); err == nil {
+		t.Fatal("expected an error for an invalid namespace")
+	}
+}
+
+func TestOptimizeOAFGeopackageExternalFidQuotedColumns(t *testing.T) {
+	config, err := ParseOafConfig([]byte(`{"layers":{"pand":{
+		"sql-statements":[
+			"ALTER TABLE pand ADD COLUMN \"Pand Id\" TEXT",
+			"ALTER TABLE pand ADD COLUMN \"order\" INTEGER",
+			"UPDATE pand SET \"Pand Id\" = identificatie, \"order\" = fid"],
+		"external-fid-columns":["Pand Id","order"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	expected := uuid.NewSHA1(uuid.MustParse(pdokNamespace), []byte("pandNL.IMBAG.Pand.11")).String()
+	for name, opts := range map[string][]Option{
+		"rows":        nil,
+		"single pass": {WithSinglePass()},
+	} {
+		outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+		err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, append(opts, WithOutput(outputGeopackage))...)
+		if err != nil {
+			t.Fatalf("error optimizing GeoPackage (%s): %s", name, err)
+		}
+		db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+		if err != nil {
+			t.Fatalf("error opening GeoPackage: %s", err)
+		}
+		var externalFid string
+		err = db.QueryRow("select external_fid from 'pand' where fid = 1").Scan(&externalFid)
+		db.Close()
+		if err != nil {
+			t.Fatalf("error executing query: %s", err)
+		}
+		if externalFid != expected {
+			t.Fatalf("expected external_fid '%s' (%s), got '%s'", expected, name, externalFid)
+		}
+	}
+
+	// with chunk scope the schema changes are committed immediately, a missing column mustn't leave any behind
+	config.Layers["pand"] = Layer{ExternalFidColumns: []string{"missing"}}
+	sourceGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	b, err := os.ReadFile("../geopackage/original_oaf.gpkg")
+	if err != nil {
+		t.Fatalf("error reading source GeoPackage: %s", err)
+	}
+	if err = os.WriteFile(sourceGeopackage, b, 0644); err != nil {
+		t.Fatalf("error copying GeoPackage: %s", err)
+	}
+	err = OptimizeOAFFile(context.Background(), sourceGeopackage, config, WithTransactionScope(TransactionScopeChunk))
+	var optimizerErr *Error
+	if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepResolveColumns || optimizerErr.Column != "missing" {
+		t.Fatalf("expected an error for a missing external-fid-column, got: '%v'", err)
+	}
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	columnAdded, err := columnExists(context.Background(), "pand", "external_fid", db)
+	if err != nil {
+		t.Fatalf("error inspecting GeoPackage: %s", err)
+	}
+	registered, err := tableExists(context.Background(), externalFidMetadataTable, db)
+	db.Close()
+	if err != nil {
+		t.Fatalf("error inspecting GeoPackage: %s", err)
+	}
+	if columnAdded || registered {
+		t.Fatalf("expected no external_fid column and no %s after a missing external-fid-column, got: %t and %t", externalFidMetadataTable, columnAdded, registered)
+	}
+
+	// an empty list, which only a config built in code can have, generates no external_fid
+	config.Layers["pand"] = Layer{ExternalFidColumns: []string{}}
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	if err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(outputGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	db, err = OpenDB(outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+	if exists, err := columnExists(context.Background(), "pand", "external_fid", db); err != nil || exists {
+		t.Fatalf("expected no external_fid column, got: %t (%v)", exists, err)
+	}
+}
+
+func TestOptimizeOAFGeopackageQuotesInColumnNames(t *testing.T) {
+	config, err := ParseOafConfig([]byte(`{"layers":{"pand":{
+		"sql-statements":[
+			"ALTER TABLE pand ADD COLUMN \"Pand \"\"Id\"\"\" TEXT",
+			"ALTER TABLE pand ADD COLUMN \"bouw\"\"jaar\" INTEGER",
+			"UPDATE pand SET \"Pand \"\"Id\"\"\" = identificatie, \"bouw\"\"jaar\" = bouwjaar"],
+		"external-fid-columns":["Pand \"Id\""],
+		"temporal-columns":["bouw\"jaar"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	expected := uuid.NewSHA1(uuid.MustParse(pdokNamespace), []byte("pandNL.IMBAG.Pand.1")).String()
+	for name, opts := range map[string][]Option{
+		"single update": nil,
+		"chunks":        {WithChunkSize(2)},
+		"single pass":   {WithSinglePass()},
+	} {
+		outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+		err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, append(opts, WithOutput(outputGeopackage))...)
+		if err != nil {
+			t.Fatalf("error optimizing GeoPackage (%s): %s", name, err)
+		}
+		db, err := OpenDB(outputGeopackage)
+		if err != nil {
+			t.Fatalf("error opening GeoPackage: %s", err)
+		}
+		var externalFid string
+		var indexed bool
+		err = db.QueryRow("select external_fid from 'pand' where fid = 1").Scan(&externalFid)
+		if err == nil {
+			err = db.QueryRow("select exists(select 1 from pragma_index_info('pand_temporal_idx') where name = 'bouw\"jaar')").Scan(&indexed)
+		}
+		db.Close()
+		if err != nil {
+			t.Fatalf("error executing query: %s", err)
+		}
+		if externalFid != expected || !indexed {
+			t.Fatalf("expected external_fid '%s' and an indexed temporal column (%s), got '%s' and %t", expected, name, externalFid, indexed)
+		}
+
+		if _, err = RevertFile(context.Background(), outputGeopackage); err != nil {
+			t.Fatalf("error reverting GeoPackage (%s): %s", name, err)
+		}
+	}
+}
+
+func TestOptimizeOAFGeopackageDuplicateExternalFids(t *testing.T) {
+	optimize := func(policy string) (string, []DuplicateExternalFid, error) {
+		dir := t.TempDir()
+		report := filepath.Join(dir, "duplicates.json")
+		config, err := ParseOafConfig([]byte(fmt.Sprintf(`{"external-fid-report":"%s","layers":{"pand":{
+			"sql-statements":["UPDATE pand SET identificatie = 'NL.IMBAG.Pand.1' WHERE fid = 2"],
+			"external-fid-columns":["identificatie"],"external-fid-duplicates":"%s","external-fid-tiebreaker":"bouwjaar"}}}`, report, policy)))
+		if err != nil {
+			t.Fatalf("error parsing config: %s", err)
+		}
+		outputGeopackage := filepath.Join(dir, "geopackage.gpkg")
+		optimizeErr := OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(outputGeopackage))
+
+		var duplicates []DuplicateExternalFid
+		b, err := os.ReadFile(report)
+		if err != nil {
+			t.Fatalf("error reading report: %s", err)
+		}
+		if err = json.Unmarshal(b, &duplicates); err != nil {
+			t.Fatalf("error decoding report: %s", err)
+		}
+		return outputGeopackage, duplicates, optimizeErr
+	}
+
+	_, duplicates, err := optimize(ExternalFidDuplicatesWarn)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	if len(duplicates) != 1 || duplicates[0].Resolved || len(duplicates[0].Rows) != 2 ||
+		duplicates[0].Rows[0].Fid != 1 || duplicates[0].Rows[1].Fid != 2 || duplicates[0].Rows[1].Key[0] != "NL.IMBAG.Pand.1" {
+		t.Fatalf("unexpected duplicates: %+v", duplicates)
+	}
+
+	for _, policy := range []string{ExternalFidDuplicatesFail, ExternalFidDuplicatesUnique} {
+		outputGeopackage, duplicates, err := optimize(policy)
+		var optimizerErr *Error
+		if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepGenerateExternalFid {
+			t.Fatalf("expected external_fid error with policy '%s', got: %v", policy, err)
+		}
+		if len(duplicates) != 1 || len(duplicates[0].Rows) != 2 || duplicates[0].Rows[0].Fid != 1 || duplicates[0].Rows[1].Fid != 2 {
+			t.Fatalf("expected the duplicates of fid 1 and 2 to be reported with policy '%s', got: %+v", policy, duplicates)
+		}
+		if _, err = os.Stat(outputGeopackage); !os.IsNotExist(err) {
+			t.Fatalf("expected no output with policy '%s'", policy)
+		}
+	}
+
+	outputGeopackage, duplicates, err := optimize(ExternalFidDuplicatesTiebreaker)
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	if len(duplicates) != 1 || !duplicates[0].Resolved {
+		t.Fatalf("expected resolved duplicates, got: %+v", duplicates)
+	}
+	db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+	var distinct int
+	if err = db.QueryRow("select count(distinct external_fid) from pand").Scan(&distinct); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if distinct != 4 {
+		t.Fatalf("expected 4 distinct external_fids, got %d", distinct)
+	}
+	var first, second string
+	if err = db.QueryRow("select (select external_fid from pand where fid = 1), (select external_fid from pand where fid = 2)").Scan(&first, &second); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if first == duplicates[0].ExternalFid || second == duplicates[0].ExternalFid || first == second {
+		t.Fatalf("expected the tiebreaker to give fid 1 and 2 distinct external_fids, got '%s' and '%s'", first, second)
+	}
+	var externalFid string
+	if err = db.QueryRow("select external_fid from pand where fid = 3").Scan(&externalFid); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if expected := uuid.NewSHA1(uuid.MustParse(pdokNamespace), []byte("pandNL.IMBAG.Pand.3")).String(); externalFid != expected {
+		t.Fatalf("expected external_fid of rows without duplicates to be unchanged, got '%s'", externalFid)
+	}
+	var unique int
+	if err = db.QueryRow(`select "unique" from pragma_index_list('pand') where name = 'pand_external_fid_idx'`).Scan(&unique); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	if unique != 1 {
+		t.Fatal("expected a UNIQUE external_fid index")
+	}
+
+	if _, err = ParseOafConfig([]byte(`{"layers":{"pand":{"external-fid-duplicates":"tiebreaker"}}}`)); err == nil {
+		t.Fatal("expected an error for a missing tiebreaker column")
+	}
+}
+
+func TestOptimizeOAFGeopackageReport(t *testing.T) {
+	config, err := ParseOafConfig([]byte(`{"layers":{"pand":{"external-fid-columns":["identificatie"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	report := &Report{}
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(outputGeopackage), WithReport(report))
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	if report.ServiceType != "oaf" || report.SQLiteVersion == "" || report.Error != "" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	info, err := os.Stat(outputGeopackage)
+	if err != nil {
+		t.Fatalf("error reading output: %s", err)
+	}
+	if report.Before == nil || report.After == nil || report.After.Size != info.Size() || report.After.PageCount <= report.Before.PageCount {
+		t.Fatalf("unexpected file stats: %+v %+v", report.Before, report.After)
+	}
+	if len(report.Tables) != 1 || report.Tables[0].Table != "pand" {
+		t.Fatalf("expected only table 'pand' in report, got %+v", report.Tables)
+	}
+	pand := report.Tables[0]
+	var externalFidRows int64 = -1
+	for _, step := range pand.Steps {
+		if step.Step == StepGenerateExternalFid {
+			externalFidRows = step.Rows
+		}
+	}
+	if externalFidRows != 4 {
+		t.Fatalf("expected external_fid to be generated for 4 rows, got %d", externalFidRows)
+	}
+	if len(pand.ColumnsAdded) != 5 || pand.ColumnsAdded[0] != (AddedColumn{Name: "external_fid", Type: "TEXT"}) {
+		t.Fatalf("unexpected columns added: %+v", pand.ColumnsAdded)
+	}
+	if len(pand.IndexesCreated) != 2 || pand.IndexesCreated[1].Name != "pand_spatial_idx" || len(pand.IndexesCreated[1].Columns) != 5 {
+		t.Fatalf("unexpected indexes created: %+v", pand.IndexesCreated)
+	}
+	if len(report.Warnings) != 1 || report.Warnings[0] != "no config found for gpkg table 'layer'" {
+		t.Fatalf("unexpected warnings: %v", report.Warnings)
+	}
+
+	// the report is written as JSON by the -report flag
+	b, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("error encoding report: %s", err)
+	}
+	var decoded struct {
+		ServiceType string `json:"serviceType"`
+		After       struct {
+			Size int64 `json:"size"`
+		} `json:"after"`
+		Tables []struct {
+			Table        string `json:"table"`
+			ColumnsAdded []struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"columnsAdded"`
+			IndexesCreated []struct {
+				Name    string   `json:"name"`
+				Columns []string `json:"columns"`
+			} `json:"indexesCreated"`
+		} `json:"tables"`
+		Warnings []string `json:"warnings"`
+		Error    *string  `json:"error"`
+	}
+	if err = json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("error decoding report: %s", err)
+	}
+	if decoded.ServiceType != "oaf" || decoded.After.Size != info.Size() || decoded.Error != nil || len(decoded.Warnings) != 1 ||
+		len(decoded.Tables) != 1 || decoded.Tables[0].Table != "pand" || decoded.Tables[0].ColumnsAdded[0].Name != "external_fid" ||
+		decoded.Tables[0].IndexesCreated[0].Name != "pand_external_fid_idx" || decoded.Tables[0].IndexesCreated[0].Columns[0] != "external_fid" {
+		t.Fatalf("unexpected JSON report: %s", b)
+	}
+}
+
+func TestOptimizeOAFGeopackageStructuredLogging(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	defer slog.SetDefault(defaultLogger)
+
+	config, err := ParseOafConfig([]byte(`{"layers":{"pand":{"external-fid-columns":["identificatie"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(outputGeopackage))
+	if err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	var foundStep, foundWarning bool
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]any
+		if err = decoder.Decode(&record); err != nil {
+			t.Fatalf("error decoding log record: %s", err)
+		}
+		if record["level"] == "DEBUG" {
+			t.Fatalf("unexpected debug record: %v", record)
+		}
+		if record["msg"] == "finished step" && record["step"] == string(StepGenerateExternalFid) {
+			foundStep = record["table"] == "pand" && record["rows"] == float64(4) && record["duration"] != nil
+		}
+		if record["level"] == "WARN" && record["table"] == "layer" {
+			foundWarning = true
+		}
+	}
+	if !foundStep || !foundWarning {
+		t.Fatalf("expected structured step and warning records, got:\n%s", buf.String())
+	}
+
+	// a failed run is logged at error level
+	buf.Reset()
+	config.Layers["pand"] = Layer{ExternalFidColumns: []string{"missing"}}
+	if err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(outputGeopackage)); err == nil {
+		t.Fatal("expected error optimizing GeoPackage")
+	}
+	var foundError bool
+	decoder = json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]any
+		if err = decoder.Decode(&record); err != nil {
+			t.Fatalf("error decoding log record: %s", err)
+		}
+		if record["level"] == "ERROR" && record["msg"] == "rolling back transaction" {
+			foundError = strings.Contains(fmt.Sprint(record["error"]), "table 'pand'")
+		}
+	}
+	if !foundError {
+		t.Fatalf("expected an error record for the failed run, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,89 @@
+package optimizer
+
+// Option configures how an optimization run is performed
+type Option func(*options)
+
+type options struct {
+	output           string
+	transactionScope TransactionScope
+	workers          int
+	chunkSize        int
+	singlePass       bool
+	report           *Report
+	// requireSpatiaLite fails the run when SpatiaLite isn't loaded, instead of using the Go implemented fallbacks
+	requireSpatiaLite bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{transactionScope: TransactionScopeRun, workers: 1, chunkSize: defaultChunkSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithOutput leaves the source GeoPackage untouched and writes the optimized GeoPackage to the given path instead.
+// The output only appears once all optimizations succeeded.
+func WithOutput(outputGeopackage string) Option {
+	return func(o *options) {
+		o.output = outputGeopackage
+	}
+}
+
+// WithTransactionScope determines whether all changes are made in one transaction (the default),
+// in a transaction per table or in a transaction per chunk of rows
+func WithTransactionScope(scope TransactionScope) Option {
+	return func(o *options) {
+		o.transactionScope = scope
+	}
+}
+
+// WithWorkers sets the number of tables optimized concurrently, and the number of workers computing values
+// (puuids, external_fids, envelopes) within a table. Writes to the GeoPackage are always serialized.
+// Tables are optimized concurrently with TransactionScopeRun and TransactionScopeChunk, with TransactionScopeTable
+// they are optimized one at a time as every table has its own transaction.
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		o.workers = max(workers, 1)
+	}
+}
+
+// WithChunkSize sets the number of rows that are read, computed and written at a time when generating
+// puuids, external_fids and envelopes
+func WithChunkSize(chunkSize int) Option {
+	return func(o *options) {
+		if chunkSize > 0 {
+			o.chunkSize = chunkSize
+		}
+	}
+}
+
+// WithSinglePass fills the random puuid, external_fid and envelope columns with a single UPDATE using Go
+// implemented SQL functions, instead of row by row in chunks. This is the fastest option for a single worker, but
+// can't be committed per chunk.
+func WithSinglePass() Option {
+	return func(o *options) {
+		o.singlePass = true
+	}
+}
+
+// WithReport fills the given report while optimizing, with the steps performed per table, the columns and indexes
+// added and the size of the GeoPackage before and after
+func WithReport(report *Report) Option {
+	return func(o *options) {
+		o.report = report
+	}
+}
+
+// WithRequireSpatiaLite fails the run before making changes when the SpatiaLite extension couldn't be loaded,
+// instead of continuing with the Go implemented fallbacks of the spatial functions
+func WithRequireSpatiaLite() Option {
+	return func(o *options) {
+		o.requireSpatiaLite = true
+	}
+}
+
+// chunked reports whether computed columns that can also be filled in a single pass are filled in chunks instead
+func (o *options) chunked() bool {
+	return !o.singlePass && (o.workers > 1 || o.transactionScope == TransactionScopeChunk)
+}
@@ -0,0 +1,83 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+func addOWSDefaultOptimizations(ctx context.Context, tableName string, generator puuidGenerator, db dbtx) error {
+	columnName := "puuid"
+	if err := addColumn(ctx, tableName, columnName, "TEXT", db); err != nil {
+		return err
+	}
+	if err := generatePUUIDs(ctx, tableName, columnName, generator, db); err != nil {
+		return err
+	}
+	if err := createIndex(ctx, tableName, []string{columnName}, "", true, db); err != nil {
+		return err
+	}
+
+	columnName = "fuuid"
+	value := fmt.Sprintf("'%s.' || puuid", tableName)
+	if err := addColumn(ctx, tableName, columnName, "TEXT", db); err != nil {
+		return err
+	}
+	if err := setColumnValue(ctx, tableName, columnName, value, db); err != nil {
+		return err
+	}
+	return createIndex(ctx, tableName, []string{columnName}, "", true, db)
+}
+
+func generatePUUIDs(ctx context.Context, tableName string, columnName string, generator puuidGenerator, db dbtx) error {
+	if runOptions(db).singlePass && len(generator.keyColumns()) == 0 {
+		// random puuids don't depend on the row, so pdok_uuid4 fills them in a single UPDATE
+		query := fmt.Sprintf("UPDATE %s SET %[2]s = pdok_uuid4() WHERE %[2]s IS NULL;", quoteIdentifier(tableName), quoteIdentifier(columnName))
+		slog.Debug("executing query", "table", tableName, "query", query)
+		start := time.Now()
+		result, err := db.ExecContext(ctx, query)
+		if err != nil {
+			return newError(StepGeneratePUUID, tableName, columnName, err)
+		}
+		recordStep(db, tableName, StepGeneratePUUID, columnName, rowsAffected(result), start)
+		return nil
+	}
+	if _, ok := db.(*planner); !ok {
+		slog.Info("generating puuids", "table", tableName, "method", generator.description())
+		if err := generator.prepare(ctx); err != nil {
+			return newError(StepGeneratePUUID, tableName, columnName, err)
+		}
+	}
+
+	// only rows without a puuid (e.g. all rows on the first run) get one, existing puuids are preserved on re-runs
+	update := rowUpdate{
+		table:         tableName,
+		step:          StepGeneratePUUID,
+		columns:       []string{columnName},
+		selectColumns: []string{quoteIdentifier(columnName) + " IS NULL"},
+		description:   generator.description() + " per row without puuid",
+	}
+	for _, keyColumn := range generator.keyColumns() {
+		update.selectColumns = append(update.selectColumns, quoteIdentifier(keyColumn))
+	}
+	if len(generator.keyColumns()) == 0 {
+		update.where = quoteIdentifier(columnName) + " IS NULL"
+	}
+	update.compute = func(values []any) ([]any, error) {
+		missing, key := values[0] == int64(1), values[1:]
+		if !missing {
+			return nil, generator.existing(key)
+		}
+		puuid, err := generator.puuid(key)
+		if err != nil {
+			return nil, err
+		}
+		return []any{puuid}, nil
+	}
+
+	if _, err := updateRows(ctx, db, update); err != nil {
+		return newError(StepGeneratePUUID, tableName, columnName, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package optimizer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// PUUID modes
+const (
+	PUUIDModeRandom          = "random"
+	PUUIDModeDeterministic   = "deterministic"
+	PUUIDModePreviousRelease = "previous-release"
+)
+
+const defaultPUUIDSecretEnv = "PUUID_SECRET"
+
+type OwsConfig struct {
+	Indices []ManualIndex `json:"indices" jsonschema_description:"Additional indices to create"`
+	PUUID   *PUUIDConfig  `json:"puuid,omitempty" jsonschema_description:"How puuid values are generated, defaults to random UUIDv4"`
+}
+
+type PUUIDConfig struct {
+	Mode               string              `json:"mode" jsonschema:"required,enum=random,enum=deterministic,enum=previous-release"`
+	KeyColumns         map[string][]string `json:"key-columns,omitempty" jsonschema_description:"Business key columns per table, used to derive or match the puuid. Tables without key columns get random puuids"`
+	SecretEnv          string              `json:"secret-env,omitempty" jsonschema_description:"Environment variable holding the secret that salts deterministic puuids, defaults to PUUID_SECRET"`
+	PreviousGeopackage string              `json:"previous-geopackage,omitempty" jsonschema_description:"GeoPackage of the previous release to carry the puuids over from"`
+	RemovedReport      string              `json:"removed-report,omitempty" jsonschema_description:"File to write the puuids of the previous release that no longer exist to, as JSON"`
+}
+
+type ManualIndex struct {
+	Name    string   `json:"name" jsonschema:"required"`
+	Table   string   `json:"table" jsonschema:"required"`
+	Unique  bool     `json:"unique"`
+	Columns []string `json:"columns" jsonschema:"required,minItems=1"`
+}
+
+// ParseOwsConfig parses the given JSON or YAML config and validates it against the OWS config JSON Schema
+func ParseOwsConfig(config []byte) (*OwsConfig, error) {
+	var owsConfig OwsConfig
+	err := decodeConfig(config, owsConfigSchemaName, &owsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &owsConfig, nil
+}
+
+func (c *OwsConfig) validate() error {
+	foundNames := make(map[string]bool)
+	for _, index := range c.Indices {
+		if foundNames[index.Name] {
+			return newError(StepConfig, index.Table, "", fmt.Errorf("index name '%s' was found more than once", index.Name))
+		}
+		foundNames[index.Name] = true
+	}
+	if c.PUUID != nil {
+		return c.PUUID.validate()
+	}
+	return nil
+}
+
+func (c *PUUIDConfig) validate() error {
+	switch c.Mode {
+	case PUUIDModeRandom:
+	case PUUIDModeDeterministic:
+		if c.secret() == "" {
+			return newError(StepConfig, "", "", fmt.Errorf("puuid mode '%s' requires a secret in environment variable '%s'", c.Mode, c.secretEnv()))
+		}
+	case PUUIDModePreviousRelease:
+		if c.PreviousGeopackage == "" {
+			return newError(StepConfig, "", "", fmt.Errorf("puuid mode '%s' requires previous-geopackage", c.Mode))
+		}
+	default:
+		return newError(StepConfig, "", "", fmt.Errorf("invalid puuid mode '%s'", c.Mode))
+	}
+	for table, columns := range c.KeyColumns {
+		if len(columns) == 0 {
+			return newError(StepConfig, table, "", errors.New("no key columns configured"))
+		}
+	}
+	return nil
+}
+
+func (c *PUUIDConfig) secretEnv() string {
+	if c.SecretEnv == "" {
+		return defaultPUUIDSecretEnv
+	}
+	return c.SecretEnv
+}
+
+func (c *PUUIDConfig) secret() string {
+	return os.Getenv(c.secretEnv())
+}
@@ -0,0 +1,108 @@
+package optimizer
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// concurrentTx is the transaction shared by units of work that run concurrently. SQLite has a single
+// writer, so all database access is serialized through mu: a unit holds mu while it uses the database
+// and only releases it while computing values in updateRows, allowing other units to proceed meanwhile.
+type concurrentTx struct {
+	dbtx
+	mu *sync.Mutex
+	o  *options
+}
+
+// runOptions returns the options of the run the given database is used in
+func runOptions(db dbtx) *options {
+	if c, ok := db.(*concurrentTx); ok {
+		return c.o
+	}
+	return newOptions(nil)
+}
+
+// applyUnits applies the units of work to the transaction (or directly to the database when committing per chunk),
+// with up to the configured number of tables concurrently. The units of a single table are applied in order.
+// The time spent per table is logged.
+func applyUnits(tx dbtx, o *options, units []unitOfWork) error {
+	var tables []string
+	unitsPerTable := make(map[string][]unitOfWork)
+	for _, unit := range units {
+		if _, ok := unitsPerTable[unit.table]; !ok {
+			tables = append(tables, unit.table)
+		}
+		unitsPerTable[unit.table] = append(unitsPerTable[unit.table], unit)
+	}
+
+	shared := &concurrentTx{dbtx: tx, mu: &sync.Mutex{}, o: o}
+	queue := make(chan string, len(tables))
+	for _, table := range tables {
+		queue <- table
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	timings := make(map[string]time.Duration)
+	for i := 0; i < min(o.workers, len(tables)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for table := range queue {
+				mu.Lock()
+				failed := firstErr != nil
+				mu.Unlock()
+				if failed {
+					return // the transaction is rolled back anyway, don't start on other tables
+				}
+
+				start := time.Now()
+				err := applyTableUnits(shared, unitsPerTable[table])
+				elapsed := time.Since(start)
+
+				mu.Lock()
+				timings[table] = elapsed
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				o.report.tableDone(table, elapsed)
+				slog.Info("optimized table", "table", table, "duration", elapsed.Round(time.Millisecond))
+			}
+		}()
+	}
+	wg.Wait()
+	logTimings(timings)
+	return firstErr
+}
+
+func applyTableUnits(tx *concurrentTx, units []unitOfWork) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for _, unit := range units {
+		if err := unit.apply(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func logTimings(timings map[string]time.Duration) {
+	if len(timings) < 2 {
+		return
+	}
+	tables := make([]string, 0, len(timings))
+	for table := range timings {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return timings[tables[i]] > timings[tables[j]] })
+	attrs := make([]any, len(tables))
+	for i, table := range tables {
+		attrs[i] = slog.Duration(table, timings[table].Round(time.Millisecond))
+	}
+	slog.Info("time spent per table", slog.Group("duration", attrs...))
+}
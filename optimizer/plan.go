@@ -0,0 +1,163 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Plan lists the changes an optimization run would make, without making them
+type Plan struct {
+	ServiceType string      `json:"serviceType"`
+	Tables      []TablePlan `json:"tables"`
+}
+
+// TablePlan lists the statements that would be executed for a table, in order
+type TablePlan struct {
+	Table         string             `json:"table"`
+	EstimatedRows int64              `json:"estimatedRows"`
+	Statements    []PlannedStatement `json:"statements"`
+}
+
+// PlannedStatement is a statement that would be executed. Statements executed once
+// per row have a description of the generated values and the number of rows.
+type PlannedStatement struct {
+	SQL         string `json:"sql"`
+	Args        []any  `json:"args,omitempty"`
+	Description string `json:"description,omitempty"`
+	Rows        int64  `json:"rows,omitempty"`
+}
+
+// String renders the plan as human-readable text
+func (p *Plan) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Plan for %s optimizations:\n", strings.ToUpper(p.ServiceType))
+	for _, table := range p.Tables {
+		fmt.Fprintf(&sb, "\ntable '%s' (~%d rows):\n", table.Table, table.EstimatedRows)
+		for _, stmt := range table.Statements {
+			fmt.Fprintf(&sb, "  %s", strings.Join(strings.Fields(stmt.SQL), " "))
+			if len(stmt.Args) > 0 {
+				fmt.Fprintf(&sb, " -- args: %v", stmt.Args)
+			}
+			if stmt.Description != "" {
+				fmt.Fprintf(&sb, " -- %s, for ~%d rows", stmt.Description, stmt.Rows)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// PlanOWSFile opens the GeoPackage at the given path and plans the OWS optimizations, without changing the GeoPackage
+func PlanOWSFile(ctx context.Context, sourceGeopackage string, config *OwsConfig) (*Plan, error) {
+	db, err := openExisting(sourceGeopackage)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return PlanOWS(ctx, db, config)
+}
+
+// PlanOAFFile opens the GeoPackage at the given path and plans the OAF optimizations, without changing the GeoPackage
+func PlanOAFFile(ctx context.Context, sourceGeopackage string, config *OafConfig) (*Plan, error) {
+	db, err := openExisting(sourceGeopackage)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return PlanOAF(ctx, db, config)
+}
+
+// PlanOWS lists the changes OptimizeOWS would make to the given GeoPackage
+func PlanOWS(ctx context.Context, db *sql.DB, config *OwsConfig) (*Plan, error) {
+	units, _, err := owsUnits(ctx, db, config, newOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+	return plan(ctx, db, "ows", units)
+}
+
+// PlanOAF lists the changes OptimizeOAF would make to the given GeoPackage. Note that the effects
+// of configured sql-statements aren't taken into account, since these aren't executed.
+func PlanOAF(ctx context.Context, db *sql.DB, config *OafConfig) (*Plan, error) {
+	units, _, err := oafUnits(ctx, db, config, newOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+	return plan(ctx, db, "oaf", units)
+}
+
+func plan(ctx context.Context, db *sql.DB, serviceType string, units []unitOfWork) (*Plan, error) {
+	slog.Info(fmt.Sprintf("planning %s optimizations", strings.ToUpper(serviceType)))
+	result := &Plan{ServiceType: serviceType}
+	tablePlans := make(map[string]int)
+	for _, unit := range units {
+		i, ok := tablePlans[unit.table]
+		if !ok {
+			rows, err := estimateRowCount(ctx, unit.table, db)
+			if err != nil {
+				return nil, err
+			}
+			result.Tables = append(result.Tables, TablePlan{Table: unit.table, EstimatedRows: rows})
+			i = len(result.Tables) - 1
+			tablePlans[unit.table] = i
+		}
+		if err := unit.apply(&planner{db: db, table: &result.Tables[i]}); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// estimateRowCount uses the feature count maintained by GDAL when available, and counts the rows otherwise
+func estimateRowCount(ctx context.Context, tableName string, db dbtx) (int64, error) {
+	var count sql.NullInt64
+	err := db.QueryRowContext(ctx,
+		"SELECT feature_count FROM gpkg_ogr_contents WHERE lower(table_name) = lower(?)",
+		tableName).Scan(&count)
+	if err == nil && count.Valid {
+		return count.Int64, nil
+	}
+	err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", quoteIdentifier(tableName))).Scan(&count)
+	if err != nil {
+		return 0, newError(StepInspectSchema, tableName, "", fmt.Errorf("error counting rows: %w", err))
+	}
+	return count.Int64, nil
+}
+
+// planner records the statements that would be executed instead of executing them, while
+// queries are executed against the GeoPackage to inspect the existing schema
+type planner struct {
+	db    *sql.DB
+	table *TablePlan
+}
+
+func (p *planner) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	p.table.Statements = append(p.table.Statements, PlannedStatement{SQL: query, Args: args})
+	return driver.RowsAffected(0), nil
+}
+
+func (p *planner) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return p.db.QueryContext(ctx, query, args...)
+}
+
+func (p *planner) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return p.db.QueryRowContext(ctx, query, args...)
+}
+
+func (p *planner) PrepareContext(_ context.Context, _ string) (*sql.Stmt, error) {
+	return nil, errors.New("prepared statements can't be planned, use planPerRow instead")
+}
+
+// planPerRow records a statement that would be executed once per row
+func (p *planner) planPerRow(query string, description string) {
+	p.table.Statements = append(p.table.Statements, PlannedStatement{
+		SQL:         query,
+		Description: description,
+		Rows:        p.table.EstimatedRows,
+	})
+}
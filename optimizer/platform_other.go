@@ -0,0 +1,31 @@
+// +build !windows
+
+package optimizer
+
+import "runtime"
+
+// preloadDependencies is a no-op on non-Windows platforms
+func preloadDependencies() {
+	// No special handling needed for non-Windows platforms
+}
+
+// spatialiteLocations returns the well-known locations of the SpatiaLite extension, in the order they're tried
+func spatialiteLocations() []string {
+	if runtime.GOOS == "darwin" {
+		return []string{
+			"/opt/homebrew/lib/mod_spatialite.dylib",
+			"/usr/local/lib/mod_spatialite.dylib",
+			"/opt/local/lib/mod_spatialite.dylib",
+		}
+	}
+	return []string{
+		"/usr/lib/mod_spatialite.so",
+		"/usr/lib/x86_64-linux-gnu/mod_spatialite.so",
+		"/usr/lib/aarch64-linux-gnu/mod_spatialite.so",
+		"/usr/lib64/mod_spatialite.so",
+		"/usr/local/lib/mod_spatialite.so",
+		"/usr/lib/mod_spatialite.so.8",
+		"/usr/lib/x86_64-linux-gnu/mod_spatialite.so.8",
+		"/usr/lib/aarch64-linux-gnu/mod_spatialite.so.8",
+	}
+}
@@ -1,10 +1,10 @@
 //go:build windows
 // +build windows
 
-package main
+package optimizer
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -30,17 +30,17 @@ var (
 // preloadDependencies tries to preload required DLLs on Windows
 // to ensure they're available before SQLite tries to load them
 func preloadDependencies() {
-	log.Println("Preloading Windows dependencies...")
+	slog.Debug("preloading Windows dependencies")
 	
 	// Add executable directory to PATH
 	execPath, err := os.Executable()
 	if err != nil {
-		log.Printf("Warning: Could not get executable path: %s", err)
+		slog.Warn("could not get executable path", "error", err)
 		return
 	}
 	
 	execDir := filepath.Dir(execPath)
-	log.Printf("Adding executable directory to PATH: %s", execDir)
+	slog.Debug("adding executable directory to PATH", "dir", execDir)
 	
 	// Update PATH to include the executable directory
 	currentPath := os.Getenv("PATH")
@@ -71,7 +71,7 @@ func preloadDependencies() {
 		
 		// Skip if file doesn't exist
 		if _, err := os.Stat(dllPath); os.IsNotExist(err) {
-			log.Printf("DLL not found: %s", dllPath)
+			slog.Debug("DLL not found", "path", dllPath)
 			continue
 		}
 		
@@ -79,13 +79,13 @@ func preloadDependencies() {
 		lazyDLL := syscall.NewLazyDLL(dll)
 		err := lazyDLL.Load()
 		if err == nil {
-			log.Printf("Found dependency via LazyDLL: %s", dll)
+			slog.Debug("found dependency via LazyDLL", "dll", dll)
 			
 			// Store the spatialite module for direct function calls if needed
 			if dll == "mod_spatialite.dll" {
 				spatialiteDLL = lazyDLL
 				spatialiteInit = spatialiteDLL.NewProc("sqlite3_spatialite_init")
-				log.Printf("Successfully loaded spatialite_init function")
+				slog.Debug("loaded spatialite_init function")
 			}
 			continue
 		}
@@ -94,13 +94,13 @@ func preloadDependencies() {
 		lazyDLL = syscall.NewLazyDLL(dllPath)
 		err = lazyDLL.Load()
 		if err == nil {
-			log.Printf("Found dependency via LazyDLL with path: %s", dllPath)
+			slog.Debug("found dependency via LazyDLL", "path", dllPath)
 			
 			// Store the spatialite module for direct function calls if needed
 			if dll == "mod_spatialite.dll" {
 				spatialiteDLL = lazyDLL
 				spatialiteInit = spatialiteDLL.NewProc("sqlite3_spatialite_init")
-				log.Printf("Successfully loaded spatialite_init function via path")
+				slog.Debug("loaded spatialite_init function", "path", dllPath)
 			}
 			continue
 		}
@@ -114,20 +114,20 @@ func preloadDependencies() {
 		)
 		
 		if handle != 0 {
-			log.Printf("Found dependency via LoadLibraryEx: %s", dll)
+			slog.Debug("found dependency via LoadLibraryEx", "dll", dll)
 		} else {
-			log.Printf("Failed to preload: %s - %s", dll, err)
+			slog.Debug("failed to preload", "dll", dll, "error", err)
 		}
 	}
 	
 	// Try to directly initialize SpatiaLite if we loaded the DLL successfully
 	if spatialiteInit != nil {
-		log.Printf("Attempting direct SpatiaLite initialization...")
+		slog.Debug("attempting direct SpatiaLite initialization")
 		ret, _, err := spatialiteInit.Call(0) // Pass 0 as the db handle to just test loading
 		if ret != 0 || err != syscall.Errno(0) {
-			log.Printf("Direct SpatiaLite initialization returned: %d, err: %v", ret, err)
+			slog.Debug("direct SpatiaLite initialization failed", "result", ret, "error", err)
 		} else {
-			log.Printf("Direct SpatiaLite initialization succeeded!")
+			slog.Debug("direct SpatiaLite initialization succeeded")
 		}
 	}
 	
@@ -153,33 +153,33 @@ func preloadDependencies() {
 		// Create a copy with the alternative name
 		originalBytes, err := os.ReadFile(originalPath)
 		if err != nil {
-			log.Printf("Failed to read %s: %v", originalPath, err)
+			slog.Debug("failed to read DLL", "path", originalPath, "error", err)
 			continue
 		}
 		
 		err = os.WriteFile(alternativePath, originalBytes, 0755)
 		if err != nil {
-			log.Printf("Failed to create %s: %v", alternativePath, err)
+			slog.Debug("failed to create DLL", "path", alternativePath, "error", err)
 			continue
 		}
 		
-		log.Printf("Created alternative DLL name: %s -> %s", original, alternative)
+		slog.Debug("created alternative DLL name", "dll", original, "alternative", alternative)
 	}
 	
 	// Extra diagnostics
-	log.Printf("Go version: %s", runtime.Version())
-	log.Printf("Windows architecture: %s", runtime.GOARCH)
+	slog.Debug("Go version", "version", runtime.Version())
+	slog.Debug("Windows architecture", "arch", runtime.GOARCH)
 }
 
 // loadSpatialite attempts to directly load the SpatiaLite extension
 // using various techniques specific to Windows
 func loadSpatialite(dbPath string) {
 	if spatialiteDLL == nil || spatialiteInit == nil {
-		log.Printf("Cannot load SpatiaLite directly: DLL not properly loaded")
+		slog.Debug("cannot load SpatiaLite directly, DLL not properly loaded")
 		return
 	}
 	
-	log.Printf("Attempting direct Windows-specific SpatiaLite loading...")
+	slog.Debug("attempting direct Windows-specific SpatiaLite loading")
 	
 	// Explicit fallback technique for extreme cases
 	dllDir, _ := os.Executable()
@@ -187,9 +187,21 @@ func loadSpatialite(dbPath string) {
 	
 	// Database path info for diagnostics
 	dbName := filepath.Base(dbPath)
-	log.Printf("Database info: name=%s, path=%s", dbName, dbPath)
+	slog.Debug("database info", "name", dbName, "path", dbPath)
 	
-	log.Printf("Will try both direct SQLite loading and Windows-specific loading")
+	slog.Debug("trying both direct SQLite loading and Windows-specific loading")
 	
 	// The other loading approaches are handled in utils.go
 }
+
+// spatialiteLocations returns the well-known locations of the SpatiaLite extension, in the order they're tried
+func spatialiteLocations() []string {
+	var locations []string
+	if execPath, err := os.Executable(); err == nil {
+		locations = append(locations, filepath.Join(filepath.Dir(execPath), "mod_spatialite.dll"))
+	}
+	return append(locations,
+		`C:\OSGeo4W\bin\mod_spatialite.dll`,
+		`C:\OSGeo4W64\bin\mod_spatialite.dll`,
+	)
+}
@@ -0,0 +1,300 @@
+package optimizer
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// runsTable records every optimization run compactly, for verify, revert and re-runs to query
+	runsTable = "pdok_optimizer_runs"
+
+	metadataExtension  = "gpkg_metadata"
+	metadataDefinition = "http://www.geopackage.org/spec/#extension_metadata"
+	// provenanceStandard identifies the provenance documents in gpkg_metadata
+	provenanceStandard = "https://github.com/PDOK/geopackage-optimizer-go#provenance"
+	provenanceTool     = "geopackage-optimizer-go"
+	// metadataTimestampFormat is the format of gpkg_metadata_reference.timestamp, %Y-%m-%dT%H:%M:%fZ in SQLite
+	metadataTimestampFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// Provenance describes an optimization run, it's written as JSON to gpkg_metadata for every run that succeeded
+type Provenance struct {
+	Tool        string `json:"tool"`
+	Version     string `json:"version"`
+	Commit      string `json:"commit,omitempty"`
+	ServiceType string `json:"serviceType"`
+	// Config is the effective config, including defaults, or null when the run had none
+	Config   any       `json:"config"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Artifacts are the columns, indexes and tables added by the run, see Revert
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// Run is an optimization run as recorded in the GeoPackage, its Provenance is the gpkg_metadata row with MetadataID
+type Run struct {
+	ID          int64     `json:"id"`
+	ServiceType string    `json:"serviceType"`
+	Version     string    `json:"version"`
+	Commit      string    `json:"commit,omitempty"`
+	ConfigHash  string    `json:"configHash"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	MetadataID  int64     `json:"metadataId"`
+}
+
+// runRecorder keeps track of a run in progress, to record its provenance once it succeeded
+type runRecorder struct {
+	serviceType string
+	config      any
+	started     time.Time
+	// lastArtifact is the rowid of the last artifact recorded before the run, the run added the artifacts after it
+	lastArtifact int64
+}
+
+// startRun looks up the previous run of the service type, to log whether the GeoPackage is re-optimized with the same
+// config. The config must have its defaults applied.
+func startRun(ctx context.Context, db *sql.DB, serviceType string, config any, started time.Time) (*runRecorder, error) {
+	r := &runRecorder{serviceType: serviceType, config: config, started: started}
+	previous, err := getLastRun(ctx, serviceType, db)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		hash, err := configHash(config)
+		if err != nil {
+			return nil, err
+		}
+		if hash == previous.ConfigHash {
+			slog.Info("geopackage was optimized with the same config before, only adding what's missing and refreshing derived values",
+				"version", previous.Version, "finished", previous.Finished)
+		} else {
+			slog.Info("geopackage was optimized with a different config before, optimizations that are no longer configured are kept",
+				"version", previous.Version, "finished", previous.Finished)
+		}
+	}
+	exists, err := tableExists(ctx, artifactsTable, db)
+	if err != nil || !exists {
+		return r, err
+	}
+	if err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT coalesce(max(rowid), 0) FROM %s", artifactsTable)).Scan(&r.lastArtifact); err != nil {
+		return nil, newError(StepInspectSchema, artifactsTable, "", err)
+	}
+	return r, nil
+}
+
+// record writes the provenance of the run to gpkg_metadata, referenced from gpkg_metadata_reference for the whole
+// GeoPackage, and the run to the runs table. With a transaction per run it's called in that transaction, so failing to
+// record the provenance rolls back the run.
+func (r *runRecorder) record(ctx context.Context, db dbtx) error {
+	if err := createMetadataTables(ctx, db); err != nil {
+		return err
+	}
+	artifacts, err := readArtifacts(ctx, db)
+	if err != nil {
+		return err
+	}
+	version, commit := BuildVersion()
+	provenance := Provenance{
+		Tool:        provenanceTool,
+		Version:     version,
+		Commit:      commit,
+		ServiceType: r.serviceType,
+		Config:      r.config,
+		Started:     r.started.UTC(),
+		Finished:    time.Now().UTC(),
+		Artifacts:   []Artifact{},
+	}
+	for _, a := range artifacts {
+		if a.id > r.lastArtifact {
+			provenance.Artifacts = append(provenance.Artifacts, a)
+		}
+	}
+	document, err := json.Marshal(provenance)
+	if err != nil {
+		return newError(StepRecordProvenance, "", "", fmt.Errorf("error encoding provenance: %w", err))
+	}
+	hash, err := configHash(r.config)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, "INSERT INTO gpkg_metadata (md_scope, md_standard_uri, mime_type, metadata) VALUES ('dataset', ?, 'application/json', ?)",
+		provenanceStandard, string(document))
+	if err != nil {
+		return newError(StepRecordProvenance, "", "", fmt.Errorf("error inserting gpkg_metadata: %w", err))
+	}
+	metadataID, err := result.LastInsertId()
+	if err != nil {
+		return newError(StepRecordProvenance, "", "", err)
+	}
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO gpkg_metadata_reference (reference_scope, table_name, column_name, row_id_value, timestamp, md_file_id, md_parent_id)
+			VALUES ('geopackage', NULL, NULL, NULL, ?, ?, NULL)`, []any{provenance.Finished.Format(metadataTimestampFormat), metadataID}},
+		{fmt.Sprintf(`INSERT INTO %s (service_type, version, git_commit, config_hash, started, finished, md_file_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, runsTable),
+			[]any{r.serviceType, version, commit, hash, formatTime(provenance.Started), formatTime(provenance.Finished), metadataID}},
+	}
+	for _, stmt := range statements {
+		if _, err = db.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return newError(StepRecordProvenance, "", "", err)
+		}
+	}
+	slog.Info("recorded provenance", "version", version, "commit", commit, "artifacts", len(provenance.Artifacts))
+	return nil
+}
+
+// createMetadataTables creates the tables of the GeoPackage metadata extension and the runs table, unless these
+// exist. The metadata tables are recorded as artifacts, the runs table is removed by Revert anyway.
+func createMetadataTables(ctx context.Context, db dbtx) error {
+	metadataTables := []string{"gpkg_extensions", "gpkg_metadata", "gpkg_metadata_reference"}
+	var existing []string
+	for _, table := range metadataTables {
+		exists, err := tableExists(ctx, table, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			existing = append(existing, table)
+		}
+	}
+	queries := []string{
+		createExtensionsTable,
+		`CREATE TABLE IF NOT EXISTS gpkg_metadata (
+			id INTEGER CONSTRAINT m_pk PRIMARY KEY ASC NOT NULL,
+			md_scope TEXT NOT NULL DEFAULT 'dataset',
+			md_standard_uri TEXT NOT NULL,
+			mime_type TEXT NOT NULL DEFAULT 'text/xml',
+			metadata TEXT NOT NULL DEFAULT '')`,
+		`CREATE TABLE IF NOT EXISTS gpkg_metadata_reference (
+			reference_scope TEXT NOT NULL,
+			table_name TEXT,
+			column_name TEXT,
+			row_id_value INTEGER,
+			timestamp DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+			md_file_id INTEGER NOT NULL,
+			md_parent_id INTEGER,
+			CONSTRAINT crmr_mfi_fk FOREIGN KEY (md_file_id) REFERENCES gpkg_metadata(id),
+			CONSTRAINT crmr_mpi_fk FOREIGN KEY (md_parent_id) REFERENCES gpkg_metadata(id))`,
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_type TEXT NOT NULL,
+			version TEXT NOT NULL,
+			git_commit TEXT NOT NULL,
+			config_hash TEXT NOT NULL,
+			started TEXT NOT NULL,
+			finished TEXT NOT NULL,
+			md_file_id INTEGER NOT NULL REFERENCES gpkg_metadata(id))`, runsTable),
+	}
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return newError(StepRecordProvenance, "", "", fmt.Errorf("error executing query '%s': %w", query, err))
+		}
+	}
+	if err := recordNewTables(ctx, metadataTables, existing, db); err != nil {
+		return err
+	}
+	for _, table := range metadataTables[1:] {
+		if err := registerExtension(ctx, table, metadataExtension, metadataDefinition, db); err != nil {
+			return newError(StepRecordProvenance, table, "", fmt.Errorf("error registering metadata extension: %w", err))
+		}
+	}
+	return nil
+}
+
+// getLastRun returns the last recorded run of the given service type, or nil when none is recorded
+func getLastRun(ctx context.Context, serviceType string, db dbtx) (*Run, error) {
+	runs, err := getRuns(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].ServiceType == serviceType {
+			return &runs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// getRuns returns the recorded runs in the order these were performed
+func getRuns(ctx context.Context, db dbtx) ([]Run, error) {
+	exists, err := tableExists(ctx, runsTable, db)
+	if err != nil || !exists {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id, service_type, version, git_commit, config_hash, started, finished, md_file_id FROM %s ORDER BY id", runsTable))
+	if err != nil {
+		return nil, newError(StepInspectSchema, runsTable, "", err)
+	}
+	defer rows.Close()
+	var runs []Run
+	for rows.Next() {
+		var run Run
+		var started, finished string
+		if err = rows.Scan(&run.ID, &run.ServiceType, &run.Version, &run.Commit, &run.ConfigHash, &started, &finished, &run.MetadataID); err != nil {
+			return nil, newError(StepInspectSchema, runsTable, "", err)
+		}
+		// the timestamps are written by the optimizer, an unparsable timestamp is left zero
+		run.Started, _ = time.Parse(time.RFC3339Nano, started)
+		run.Finished, _ = time.Parse(time.RFC3339Nano, finished)
+		runs = append(runs, run)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, newError(StepInspectSchema, runsTable, "", err)
+	}
+	return runs, nil
+}
+
+// removeProvenance removes the provenance of the recorded runs from gpkg_metadata and gpkg_metadata_reference, for
+// when these tables existed before the optimizer ran. Returns the runs removed.
+func removeProvenance(ctx context.Context, db dbtx) ([]Run, error) {
+	runs, err := getRuns(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		for _, registration := range []struct{ table, column string }{
+			{"gpkg_metadata_reference", "md_file_id"},
+			{"gpkg_metadata", "id"},
+		} {
+			exists, err := tableExists(ctx, registration.table, db)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				continue
+			}
+			query := fmt.Sprintf("DELETE FROM %s WHERE %s = %d", registration.table, registration.column, run.MetadataID)
+			if err = execRevert(ctx, registration.table, query, db); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return runs, nil
+}
+
+// configHash returns the SHA-256 of the config encoded as JSON, which has its map keys sorted
+func configHash(config any) (string, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return "", newError(StepConfig, "", "", fmt.Errorf("error encoding config: %w", err))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// formatTime formats the timestamps of the runs table, which getRuns parses
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
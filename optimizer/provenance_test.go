@@ -0,0 +1,125 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProvenanceOWS(t *testing.T) {
+	config, err := ParseOwsConfig([]byte(`{"indices":[{"name":"layer_fid_idx","table":"layer","columns":["fid","puuid"]}]}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	if err = OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", config, WithOutput(outputGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	if err = OptimizeOWSFile(context.Background(), outputGeopackage, config); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+	runs, err := getRuns(context.Background(), db)
+	if err != nil {
+		t.Fatalf("error reading runs: %s", err)
+	}
+	if len(runs) != 2 || runs[0].ServiceType != "ows" || runs[0].ConfigHash != runs[1].ConfigHash || runs[1].Finished.Before(runs[0].Finished) {
+		t.Fatalf("expected two OWS runs with the same config, got: %+v", runs)
+	}
+
+	var mimeType, document, referenceScope, timestamp string
+	// the driver parses DATETIME columns, the cast reads the timestamp as stored
+	err = db.QueryRow(`SELECT m.mime_type, m.metadata, r.reference_scope, CAST(r.timestamp AS TEXT) FROM gpkg_metadata m
+		JOIN gpkg_metadata_reference r ON r.md_file_id = m.id WHERE m.id = ?`, runs[0].MetadataID).Scan(&mimeType, &document, &referenceScope, &timestamp)
+	if err != nil {
+		t.Fatalf("error reading provenance: %s", err)
+	}
+	if _, err = time.Parse(metadataTimestampFormat, timestamp); err != nil || len(timestamp) != len(metadataTimestampFormat) {
+		t.Fatalf("expected a timestamp with millisecond precision, got '%s'", timestamp)
+	}
+	var provenance Provenance
+	if err = json.Unmarshal([]byte(document), &provenance); err != nil {
+		t.Fatalf("error decoding provenance: %s", err)
+	}
+	if mimeType != "application/json" || referenceScope != "geopackage" || provenance.ServiceType != "ows" || provenance.Version == "" {
+		t.Fatalf("unexpected provenance: %s %s %+v", mimeType, referenceScope, provenance)
+	}
+	if provenance.Config.(map[string]any)["indices"] == nil {
+		t.Fatalf("expected the config in the provenance, got: %+v", provenance.Config)
+	}
+	// the columns and indexes of both tables, the configured index and the metadata tables
+	if len(provenance.Artifacts) != 11 {
+		t.Fatalf("expected the artifacts of the first run in its provenance, got: %+v", provenance.Artifacts)
+	}
+	if err = db.QueryRow("SELECT metadata FROM gpkg_metadata WHERE id = ?", runs[1].MetadataID).Scan(&document); err != nil {
+		t.Fatalf("error reading provenance: %s", err)
+	}
+	if err = json.Unmarshal([]byte(document), &provenance); err != nil {
+		t.Fatalf("error decoding provenance: %s", err)
+	}
+	if len(provenance.Artifacts) != 0 {
+		t.Fatalf("expected a re-run not to add artifacts, got: %+v", provenance.Artifacts)
+	}
+	var registered int
+	if err = db.QueryRow("SELECT count(*) FROM gpkg_extensions WHERE extension_name = 'gpkg_metadata'").Scan(&registered); err != nil || registered != 2 {
+		t.Fatalf("expected the metadata extension to be registered for both tables, got %d: %v", registered, err)
+	}
+
+	verification, err := VerifyOWSFile(context.Background(), outputGeopackage, config)
+	if err != nil {
+		t.Fatalf("error verifying GeoPackage: %s", err)
+	}
+	if verification.LastRun == nil || verification.LastRun.ID != runs[1].ID || verification.ConfigChanged {
+		t.Fatalf("expected the last run with the same config, got:\n%s", verification)
+	}
+	verification, err = VerifyOWSFile(context.Background(), outputGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error verifying GeoPackage: %s", err)
+	}
+	if !verification.ConfigChanged {
+		t.Fatalf("expected the config to differ from the last run, got:\n%s", verification)
+	}
+}
+
+func TestProvenanceRollsBackRun(t *testing.T) {
+	sourceGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	if err := OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", nil, WithOutput(sourceGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	db, err := OpenDB(sourceGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	defer db.Close()
+	// remove the optimizations of the first run, and make recording the provenance of the next run fail
+	if _, err = Revert(context.Background(), db); err != nil {
+		t.Fatalf("error reverting GeoPackage: %s", err)
+	}
+	_, err = db.Exec(`CREATE TABLE gpkg_metadata (id INTEGER PRIMARY KEY NOT NULL, md_scope TEXT NOT NULL, md_standard_uri TEXT NOT NULL, mime_type TEXT NOT NULL, metadata TEXT NOT NULL);
+		CREATE TRIGGER gpkg_metadata_read_only BEFORE INSERT ON gpkg_metadata BEGIN SELECT RAISE(ABORT, 'read-only'); END;`)
+	if err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+
+	err = OptimizeOWS(context.Background(), db, nil)
+	var optimizerErr *Error
+	if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepRecordProvenance {
+		t.Fatalf("expected record provenance error, got: '%v'", err)
+	}
+	exists, err := columnExists(context.Background(), "layer", "puuid", db)
+	if err != nil {
+		t.Fatalf("error inspecting GeoPackage: %s", err)
+	}
+	if exists {
+		t.Fatal("expected the run to be rolled back when its provenance can't be recorded")
+	}
+}
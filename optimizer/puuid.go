@@ -0,0 +1,254 @@
+package optimizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// puuidGenerator provides the puuid for the rows of a single table
+type puuidGenerator interface {
+	// keyColumns are selected for every row and passed to puuid and existing
+	keyColumns() []string
+	// prepare is called once before the first row is processed
+	prepare(ctx context.Context) error
+	// puuid returns the puuid for a row without one
+	puuid(key []any) (string, error)
+	// existing is called for rows that already have a puuid, e.g. on re-runs
+	existing(key []any) error
+	// removed returns the puuids of the previous release that were not matched
+	removed() []RemovedPUUID
+	description() string
+}
+
+// RemovedPUUID is a puuid of the previous release of which the key no longer exists
+type RemovedPUUID struct {
+	Table string `json:"table"`
+	PUUID string `json:"puuid"`
+	Key   []any  `json:"key"`
+}
+
+// newPUUIDGenerator returns the generator for the given table, random UUIDv4 unless configured otherwise
+func newPUUIDGenerator(tableName string, config *PUUIDConfig, report *Report) (puuidGenerator, error) {
+	if config == nil || config.Mode == PUUIDModeRandom {
+		return randomPUUIDs{}, nil
+	}
+	columns := config.KeyColumns[tableName]
+	if len(columns) == 0 {
+		report.warn(tableName, "no puuid key columns configured for table '%s', using random puuids", tableName)
+		return randomPUUIDs{}, nil
+	}
+	switch config.Mode {
+	case PUUIDModeDeterministic:
+		pdokNamespaceUUID, err := uuid.Parse(pdokNamespace)
+		if err != nil {
+			return nil, newError(StepGeneratePUUID, tableName, "puuid", fmt.Errorf("failed to parse PDOK namespace UUID: %w", err))
+		}
+		// the secret is hashed into the namespace, so the puuids can't be derived from the (public) key values alone
+		namespace := uuid.NewSHA1(pdokNamespaceUUID, []byte(config.secret()))
+		return &deterministicPUUIDs{keyedPUUIDs: newKeyedPUUIDs(tableName, columns), namespace: namespace}, nil
+	case PUUIDModePreviousRelease:
+		return &previousReleasePUUIDs{keyedPUUIDs: newKeyedPUUIDs(tableName, columns), geopackage: config.PreviousGeopackage, report: report}, nil
+	default:
+		return nil, newError(StepConfig, tableName, "", fmt.Errorf("invalid puuid mode '%s'", config.Mode))
+	}
+}
+
+type randomPUUIDs struct{}
+
+func (randomPUUIDs) keyColumns() []string            { return nil }
+func (randomPUUIDs) prepare(_ context.Context) error { return nil }
+func (randomPUUIDs) puuid(_ []any) (string, error)   { return uuid.New().String(), nil }
+func (randomPUUIDs) existing(_ []any) error          { return nil }
+func (randomPUUIDs) removed() []RemovedPUUID         { return nil }
+func (randomPUUIDs) description() string             { return "random UUIDv4" }
+
+// keyedPUUIDs keeps track of the keys seen, the key columns have to be unique for puuids based on them to be unique
+type keyedPUUIDs struct {
+	table   string
+	columns []string
+	mu      sync.Mutex
+	seen    map[string]bool
+}
+
+func newKeyedPUUIDs(tableName string, columns []string) *keyedPUUIDs {
+	return &keyedPUUIDs{table: tableName, columns: columns, seen: make(map[string]bool)}
+}
+
+func (k *keyedPUUIDs) keyColumns() []string {
+	return k.columns
+}
+
+// see returns the canonical form of the key, or an error when the key was seen before
+func (k *keyedPUUIDs) see(key []any) (string, error) {
+	canonical, err := canonicalKey(key)
+	if err != nil {
+		return "", err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.seen[canonical] {
+		return "", fmt.Errorf("key columns %v are not unique, found %s more than once", k.columns, canonical)
+	}
+	k.seen[canonical] = true
+	return canonical, nil
+}
+
+// canonicalKey encodes the key values unambiguously, as JSON array
+func canonicalKey(key []any) (string, error) {
+	b, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("error encoding key %v: %w", key, err)
+	}
+	return string(b), nil
+}
+
+// deterministicPUUIDs derives the puuid as UUIDv5 from the table name and key values
+type deterministicPUUIDs struct {
+	*keyedPUUIDs
+	namespace uuid.UUID
+}
+
+func (d *deterministicPUUIDs) prepare(_ context.Context) error { return nil }
+
+func (d *deterministicPUUIDs) puuid(key []any) (string, error) {
+	canonical, err := d.see(key)
+	if err != nil {
+		return "", err
+	}
+	return uuid.NewSHA1(d.namespace, []byte(d.table+canonical)).String(), nil
+}
+
+func (d *deterministicPUUIDs) existing(key []any) error {
+	_, err := d.see(key)
+	return err
+}
+
+func (d *deterministicPUUIDs) removed() []RemovedPUUID { return nil }
+
+func (d *deterministicPUUIDs) description() string {
+	return fmt.Sprintf("salted UUIDv5 based on columns %v", d.columns)
+}
+
+// previousReleasePUUIDs carries the puuids over from the previous release by matching on the key columns,
+// new keys get a random UUIDv4
+type previousReleasePUUIDs struct {
+	*keyedPUUIDs
+	geopackage string
+	previous   map[string]RemovedPUUID
+	report     *Report
+}
+
+func (p *previousReleasePUUIDs) prepare(ctx context.Context) error {
+	p.previous = make(map[string]RemovedPUUID)
+	if _, err := os.Stat(p.geopackage); err != nil {
+		return fmt.Errorf("error opening previous release: %w", err)
+	}
+	db, err := OpenDB(p.geopackage)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	exists, err := tableExists(ctx, p.table, db)
+	if err != nil {
+		return err
+	}
+	if exists {
+		exists, err = columnExists(ctx, p.table, "puuid", db)
+		if err != nil {
+			return err
+		}
+	}
+	if !exists {
+		p.report.warn(p.table, "table '%s' has no puuids in previous release '%s', using random puuids", p.table, p.geopackage)
+		return nil
+	}
+
+	query := fmt.Sprintf("SELECT puuid, %s FROM %s WHERE puuid IS NOT NULL", quoteIdentifiers(p.columns), quoteIdentifier(p.table))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error selecting puuids from previous release: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var puuid string
+		key, scanArgs := keyScanArgs(len(p.columns))
+		if err = rows.Scan(append([]any{&puuid}, scanArgs...)...); err != nil {
+			return fmt.Errorf("error scanning previous release: %w", err)
+		}
+		canonical, err := canonicalKey(key)
+		if err != nil {
+			return err
+		}
+		p.previous[canonical] = RemovedPUUID{Table: p.table, PUUID: puuid, Key: key}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating previous release: %w", err)
+	}
+	slog.Info("found puuids in previous release", "table", p.table, "rows", len(p.previous))
+	return nil
+}
+
+func (p *previousReleasePUUIDs) puuid(key []any) (string, error) {
+	canonical, err := p.see(key)
+	if err != nil {
+		return "", err
+	}
+	if previous, ok := p.previous[canonical]; ok {
+		return previous.PUUID, nil
+	}
+	return uuid.New().String(), nil
+}
+
+func (p *previousReleasePUUIDs) existing(key []any) error {
+	_, err := p.see(key)
+	return err
+}
+
+func (p *previousReleasePUUIDs) removed() []RemovedPUUID {
+	var removed []RemovedPUUID
+	for canonical, previous := range p.previous {
+		if !p.seen[canonical] {
+			removed = append(removed, previous)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].PUUID < removed[j].PUUID })
+	return removed
+}
+
+func (p *previousReleasePUUIDs) description() string {
+	return fmt.Sprintf("puuid of previous release '%s' matched on columns %v, random UUIDv4 for new rows", p.geopackage, p.columns)
+}
+
+// keyScanArgs returns the key values and pointers to them to pass to Scan
+func keyScanArgs(n int) ([]any, []any) {
+	key := make([]any, n)
+	scanArgs := make([]any, n)
+	for i := range key {
+		scanArgs[i] = &key[i]
+	}
+	return key, scanArgs
+}
+
+// writeRemovedPUUIDs writes the puuids of the previous release that no longer exist to the given file as JSON
+func writeRemovedPUUIDs(path string, removed []RemovedPUUID) error {
+	if removed == nil {
+		removed = []RemovedPUUID{}
+	}
+	b, err := json.MarshalIndent(removed, "", "  ")
+	if err != nil {
+		return newError(StepWriteOutput, "", "", fmt.Errorf("error encoding removed puuids: %w", err))
+	}
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		return newError(StepWriteOutput, "", "", fmt.Errorf("error writing removed puuids: %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+const (
+	relatedTablesExtension  = "related_tables"
+	relatedTablesDefinition = "http://docs.opengeospatial.org/is/18-000/18-000.html"
+)
+
+const createExtensionsTable = `CREATE TABLE IF NOT EXISTS gpkg_extensions (
+	table_name TEXT,
+	column_name TEXT,
+	extension_name TEXT NOT NULL,
+	definition TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name))`
+
+// createRelation indexes both sides of the given relation and registers it in the GeoPackage Related Tables
+// extension, using a mapping table that links the features of the layer to the rows in the related table.
+// The foreign key column is part of the layer table, the primary key column is part of the related table.
+func createRelation(ctx context.Context, tableName string, fidColumn string, relation Relation, db dbtx) error {
+	relatedPrimaryColumn, err := validateRelation(ctx, tableName, relation, db)
+	if err != nil {
+		return err
+	}
+
+	fk, pk := relation.Columns.ForeignKey, relation.Columns.PrimaryKey
+	if err = createIndex(ctx, tableName, []string{fk}, fmt.Sprintf("%s_%s_fk_idx", tableName, fk), false, db); err != nil {
+		return err
+	}
+	if !strings.EqualFold(pk, relatedPrimaryColumn) { // a primary key is already indexed
+		if err = createIndex(ctx, relation.Table, []string{pk}, fmt.Sprintf("%s_%s_idx", relation.Table, pk), false, db); err != nil {
+			return err
+		}
+	}
+
+	return registerRelation(ctx, tableName, fidColumn, relation, relatedPrimaryColumn, db)
+}
+
+// validateRelation checks that the related table and both columns exist and returns the primary key column of the related table
+func validateRelation(ctx context.Context, tableName string, relation Relation, db dbtx) (string, error) {
+	if relation.Table == "" || relation.Columns.ForeignKey == "" || relation.Columns.PrimaryKey == "" {
+		return "", newError(StepCreateRelation, tableName, "", fmt.Errorf("relation requires a table and both 'fk' and 'pk' columns, got: %+v", relation))
+	}
+	exists, err := tableExists(ctx, relation.Table, db)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", newError(StepCreateRelation, tableName, "", fmt.Errorf("related table '%s' does not exist", relation.Table))
+	}
+	for _, column := range []struct{ table, name string }{
+		{tableName, relation.Columns.ForeignKey},
+		{relation.Table, relation.Columns.PrimaryKey},
+	} {
+		exists, err = columnExists(ctx, column.table, column.name, db)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", newError(StepCreateRelation, column.table, column.name, fmt.Errorf("relation column '%s' does not exist in table '%s'", column.name, column.table))
+		}
+	}
+	relatedPrimaryColumn, err := getPrimaryKeyColumn(ctx, relation.Table, db)
+	if err != nil {
+		return "", err
+	}
+	if relatedPrimaryColumn == "" {
+		return "", newError(StepCreateRelation, relation.Table, "", fmt.Errorf("related table '%s' has no single column primary key", relation.Table))
+	}
+	return relatedPrimaryColumn, nil
+}
+
+func registerRelation(ctx context.Context, tableName string, fidColumn string, relation Relation, relatedPrimaryColumn string, db dbtx) error {
+	relationName, err := getDataType(ctx, relation.Table, db)
+	if err != nil {
+		return err
+	}
+	if relationName == "" {
+		relationName = "attributes"
+	}
+	mappingTable := relation.MappingTable
+	if mappingTable == "" {
+		mappingTable = fmt.Sprintf("%s_%s", tableName, relation.Table)
+	}
+	// the mapping table is emptied and refilled, so it may only be a table of our own
+	if err = checkMappingTable(ctx, tableName, relation.Table, mappingTable, db); err != nil {
+		return err
+	}
+	slog.Info("registering relation", "table", tableName, "relation", relationName, "relatedTable", relation.Table, "mappingTable", mappingTable)
+
+	// the tables created below are recorded as artifacts
+	createdTables := []string{"gpkg_extensions", "gpkgext_relations", mappingTable}
+	var existingTables []string
+	for _, table := range createdTables {
+		exists, err := tableExists(ctx, table, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			existingTables = append(existingTables, table)
+		}
+	}
+
+	queries := []string{
+		createExtensionsTable,
+		`CREATE TABLE IF NOT EXISTS gpkgext_relations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			base_table_name TEXT NOT NULL,
+			base_primary_column TEXT NOT NULL DEFAULT 'id',
+			related_table_name TEXT NOT NULL,
+			related_primary_column TEXT NOT NULL DEFAULT 'id',
+			relation_name TEXT NOT NULL,
+			mapping_table_name TEXT NOT NULL UNIQUE)`,
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (base_id INTEGER NOT NULL, related_id INTEGER NOT NULL)`, quoteIdentifier(mappingTable)),
+		fmt.Sprintf(`DELETE FROM %s`, quoteIdentifier(mappingTable)),
+		fmt.Sprintf(`INSERT INTO %s (base_id, related_id) SELECT b.%s, r.%s FROM %s b JOIN %s r ON b.%s = r.%s`,
+			quoteIdentifier(mappingTable), quoteIdentifier(fidColumn), quoteIdentifier(relatedPrimaryColumn), quoteIdentifier(tableName),
+			quoteIdentifier(relation.Table), quoteIdentifier(relation.Columns.ForeignKey), quoteIdentifier(relation.Columns.PrimaryKey)),
+	}
+	for _, query := range queries {
+		slog.Debug("executing query", "table", tableName, "query", query)
+		if _, err = db.ExecContext(ctx, query); err != nil {
+			return newError(StepCreateRelation, tableName, relation.Columns.ForeignKey, fmt.Errorf("error executing query '%s': %w", query, err))
+		}
+	}
+	if err = recordNewTables(ctx, createdTables, existingTables, db); err != nil {
+		return err
+	}
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{"DELETE FROM gpkgext_relations WHERE mapping_table_name = ?", []any{mappingTable}},
+		{`INSERT INTO gpkgext_relations (base_table_name, base_primary_column, related_table_name, related_primary_column, relation_name, mapping_table_name)
+			VALUES (?, ?, ?, ?, ?, ?)`, []any{tableName, fidColumn, relation.Table, relatedPrimaryColumn, relationName, mappingTable}},
+	}
+	for _, stmt := range statements {
+		if _, err = db.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return newError(StepCreateRelation, tableName, relation.Columns.ForeignKey, fmt.Errorf("error registering relation in related tables extension: %w", err))
+		}
+	}
+	for _, table := range []string{"gpkgext_relations", mappingTable} {
+		if err = registerExtension(ctx, table, relatedTablesExtension, relatedTablesDefinition, db); err != nil {
+			return newError(StepCreateRelation, tableName, relation.Columns.ForeignKey, fmt.Errorf("error registering relation in related tables extension: %w", err))
+		}
+	}
+	return nil
+}
+
+// checkMappingTable fails when the mapping table already exists, but isn't registered in gpkgext_relations as the
+// mapping table of the given relation. This prevents a table of the GeoPackage itself from being overwritten.
+func checkMappingTable(ctx context.Context, tableName string, relatedTable string, mappingTable string, db dbtx) error {
+	exists, err := tableExists(ctx, mappingTable, db)
+	if err != nil || !exists {
+		return err
+	}
+	registered, err := tableExists(ctx, "gpkgext_relations", db)
+	if err != nil {
+		return err
+	}
+	if registered {
+		err = db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM gpkgext_relations
+			WHERE lower(mapping_table_name) = lower(?) AND lower(base_table_name) = lower(?) AND lower(related_table_name) = lower(?))`,
+			mappingTable, tableName, relatedTable).Scan(&registered)
+		if err != nil {
+			return newError(StepCreateRelation, tableName, "", fmt.Errorf("error reading gpkgext_relations: %w", err))
+		}
+	}
+	if !registered {
+		return newError(StepCreateRelation, tableName, "", fmt.Errorf("mapping table '%s' already exists and isn't the mapping table of the relation with '%s', configure another mapping-table", mappingTable, relatedTable))
+	}
+	return nil
+}
+
+// registerExtension registers the use of the extension by the given table in gpkg_extensions, unless it's registered
+func registerExtension(ctx context.Context, tableName string, extensionName string, definition string, db dbtx) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO gpkg_extensions (table_name, column_name, extension_name, definition, scope)
+		SELECT ?, NULL, ?, ?, 'read-write' WHERE NOT EXISTS (SELECT 1 FROM gpkg_extensions WHERE table_name = ? AND extension_name = ?)`,
+		tableName, extensionName, definition, tableName, extensionName)
+	return err
+}
@@ -0,0 +1,219 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Report describes what an optimization run did, see WithReport. It's filled while the run progresses, so after a
+// failure it shows how far the run got.
+type Report struct {
+	mu sync.Mutex
+
+	ServiceType       string `json:"serviceType"`
+	SQLiteVersion     string `json:"sqliteVersion"`
+	SpatiaLiteVersion string `json:"spatialiteVersion,omitempty"`
+	DurationMs        int64  `json:"durationMs"`
+	// Before is measured on the GeoPackage being optimized, which with an output is the (vacuumed) copy of the source
+	Before   *FileStats    `json:"before,omitempty"`
+	After    *FileStats    `json:"after,omitempty"`
+	Tables   []TableReport `json:"tables"`
+	Warnings []string      `json:"warnings"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// FileStats describes the size of a GeoPackage
+type FileStats struct {
+	Size      int64 `json:"size"`
+	PageCount int64 `json:"pageCount"`
+	PageSize  int64 `json:"pageSize"`
+}
+
+// TableReport lists the steps performed on a table, in order, and the columns and indexes these added
+type TableReport struct {
+	Table          string         `json:"table"`
+	DurationMs     int64          `json:"durationMs"`
+	Steps          []StepReport   `json:"steps"`
+	ColumnsAdded   []AddedColumn  `json:"columnsAdded"`
+	IndexesCreated []CreatedIndex `json:"indexesCreated"`
+}
+
+// StepReport is a single step performed on a table, with the number of rows it changed
+type StepReport struct {
+	Step       Step   `json:"step"`
+	Column     string `json:"column,omitempty"`
+	Rows       int64  `json:"rows"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// AddedColumn is a column added to a table
+type AddedColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// CreatedIndex is an index created, or rebuilt because its definition differed
+type CreatedIndex struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// begin records the service type and the SQLite and SpatiaLite versions of the run
+func (r *Report) begin(ctx context.Context, serviceType string, db *sql.DB) {
+	if r == nil {
+		return
+	}
+	sqliteVersion, spatialiteVersion := versions(ctx, db)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ServiceType = serviceType
+	r.SQLiteVersion, r.SpatiaLiteVersion = sqliteVersion, spatialiteVersion
+	if r.Tables == nil {
+		r.Tables = []TableReport{}
+	}
+	if r.Warnings == nil {
+		r.Warnings = []string{}
+	}
+}
+
+// finish records the duration and error of the run, it's deferred so err points at the result of the run
+func (r *Report) finish(start time.Time, err *error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DurationMs = time.Since(start).Milliseconds()
+	if *err != nil {
+		r.Error = (*err).Error()
+	}
+}
+
+// warn logs the warning about the given table and adds it to the report
+func (r *Report) warn(tableName string, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	slog.Warn(msg, "table", tableName)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings = append(r.Warnings, msg)
+}
+
+// tableDone records the time spent on the table
+func (r *Report) tableDone(tableName string, elapsed time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.table(tableName).DurationMs = elapsed.Milliseconds()
+}
+
+// table returns the report of the given table, added when it's not in the report yet. The caller holds mu.
+func (r *Report) table(tableName string) *TableReport {
+	for i := range r.Tables {
+		if r.Tables[i].Table == tableName {
+			return &r.Tables[i]
+		}
+	}
+	r.Tables = append(r.Tables, TableReport{
+		Table:          tableName,
+		Steps:          []StepReport{},
+		ColumnsAdded:   []AddedColumn{},
+		IndexesCreated: []CreatedIndex{},
+	})
+	return &r.Tables[len(r.Tables)-1]
+}
+
+// fileStats returns the size of the GeoPackage at the given path, which is opened as db
+func fileStats(ctx context.Context, path string, db *sql.DB) (*FileStats, error) {
+	var stats FileStats
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&stats.PageCount); err != nil {
+		return nil, newError(StepInspectSchema, "", "", fmt.Errorf("error reading page count: %w", err))
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&stats.PageSize); err != nil {
+		return nil, newError(StepInspectSchema, "", "", fmt.Errorf("error reading page size: %w", err))
+	}
+	if err := stats.updateSize(path); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (s *FileStats) updateSize(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return newError(StepInspectSchema, "", "", err)
+	}
+	s.Size = info.Size()
+	return nil
+}
+
+// versions returns the SQLite version and, when loaded, the SpatiaLite version
+func versions(ctx context.Context, db *sql.DB) (sqliteVersion string, spatialiteVersion string) {
+	_ = db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&sqliteVersion)
+	_ = db.QueryRowContext(ctx, "SELECT spatialite_version()").Scan(&spatialiteVersion)
+	return sqliteVersion, spatialiteVersion
+}
+
+// recordStep logs a step performed on a table and adds it to the report of the run the database is used in
+func recordStep(db dbtx, tableName string, step Step, column string, rows int64, start time.Time) {
+	if _, ok := db.(*planner); ok {
+		return
+	}
+	elapsed := time.Since(start)
+	attrs := []any{"table", tableName, "step", step}
+	if column != "" {
+		attrs = append(attrs, "column", column)
+	}
+	slog.Info("finished step", append(attrs, "rows", rows, "duration", elapsed.Round(time.Millisecond))...)
+	r := runOptions(db).report
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.table(tableName)
+	t.Steps = append(t.Steps, StepReport{Step: step, Column: column, Rows: rows, DurationMs: elapsed.Milliseconds()})
+}
+
+// recordColumn adds a column added to a table to the report of the run the database is used in
+func recordColumn(db dbtx, tableName string, columnName string, columnType string) {
+	r := runOptions(db).report
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.table(tableName)
+	t.ColumnsAdded = append(t.ColumnsAdded, AddedColumn{Name: columnName, Type: columnType})
+}
+
+// recordIndex adds an index created on a table to the report of the run the database is used in
+func recordIndex(db dbtx, tableName string, indexName string, columnNames []string, unique bool) {
+	r := runOptions(db).report
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.table(tableName)
+	t.IndexesCreated = append(t.IndexesCreated, CreatedIndex{Name: indexName, Columns: columnNames, Unique: unique})
+}
+
+// rowsAffected returns the number of rows changed by a statement, or 0 when unknown
+func rowsAffected(result sql.Result) int64 {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	return n
+}
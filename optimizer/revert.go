@@ -0,0 +1,310 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Reversion lists the artifacts removed from a GeoPackage by Revert
+type Reversion struct {
+	Artifacts []Artifact `json:"artifacts"`
+	// RebuiltTables are the tables whose columns couldn't be dropped, which were rebuilt with their original SQL instead
+	RebuiltTables []string `json:"rebuiltTables"`
+	// Runs are the optimization runs whose provenance was removed
+	Runs []Run `json:"runs"`
+}
+
+// String renders the reversion as human-readable text
+func (r *Reversion) String() string {
+	if len(r.Artifacts) == 0 {
+		return "nothing to revert\n"
+	}
+	var sb strings.Builder
+	sb.WriteString("Reverted:\n")
+	for _, a := range r.Artifacts {
+		fmt.Fprintf(&sb, "  - %s\n", a)
+	}
+	for _, table := range r.RebuiltTables {
+		fmt.Fprintf(&sb, "rebuilt table '%s'\n", table)
+	}
+	if len(r.Runs) > 0 {
+		fmt.Fprintf(&sb, "removed the provenance of %d runs\n", len(r.Runs))
+	}
+	return sb.String()
+}
+
+// RevertFile opens the GeoPackage at the given path and reverts the optimizations, in place unless WithOutput is given
+func RevertFile(ctx context.Context, geopackage string, opts ...Option) (*Reversion, error) {
+	slog.Info("reverting optimizations", "geopackage", geopackage)
+	var reversion *Reversion
+	err := optimizeFile(ctx, geopackage, newOptions(opts), func(db *sql.DB) (err error) {
+		reversion, err = Revert(ctx, db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reversion, nil
+}
+
+// Revert removes the columns, indexes and tables the optimizer recorded adding to the given GeoPackage, restoring
+// its original schema. Columns are dropped with ALTER TABLE DROP COLUMN, or when that isn't possible by rebuilding the
+// table with the SQL it had before the first column was added. Indexes that replaced an existing index are restored
+// to their original definition. The provenance of the runs is removed as well. All changes are made in a single
+// transaction.
+func Revert(ctx context.Context, db *sql.DB) (*Reversion, error) {
+	// foreign keys are disabled, since rebuilding a table would otherwise delete (or cascade to) the referencing rows.
+	// This can only be done outside a transaction, on the connection used for it.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, newError(StepOpen, "", "", err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, newError(StepRevert, "", "", fmt.Errorf("error disabling foreign keys: %w", err))
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, newError(StepTransaction, "", "", fmt.Errorf("error beginning transaction: %w", err))
+	}
+	reversion, err := revert(ctx, tx)
+	if err != nil {
+		slog.Error("rolling back transaction", "error", err)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			slog.Error("error rolling back transaction", "error", rollbackErr)
+		}
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, newError(StepTransaction, "", "", fmt.Errorf("error committing transaction: %w", err))
+	}
+	return reversion, nil
+}
+
+func revert(ctx context.Context, db dbtx) (*Reversion, error) {
+	artifacts, err := readArtifacts(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	reversion := &Reversion{Artifacts: []Artifact{}, RebuiltTables: []string{}, Runs: []Run{}}
+	if len(artifacts) == 0 {
+		slog.Warn("no optimizer artifacts recorded, nothing to revert")
+		return reversion, nil
+	}
+	// before the metadata tables are dropped, in case these existed before
+	runs, err := removeProvenance(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// indexes first, as an indexed column can't be dropped
+	for _, a := range artifacts {
+		if a.Kind == ArtifactIndex {
+			if err = revertIndex(ctx, a, db); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var tables []string
+	columns := make(map[string][]Artifact)
+	for _, a := range artifacts {
+		if a.Kind != ArtifactColumn {
+			continue
+		}
+		if _, ok := columns[a.Table]; !ok {
+			tables = append(tables, a.Table)
+		}
+		columns[a.Table] = append(columns[a.Table], a)
+	}
+	for _, table := range tables {
+		rebuilt, err := revertColumns(ctx, table, columns[table], db)
+		if err != nil {
+			return nil, err
+		}
+		if rebuilt {
+			reversion.RebuiltTables = append(reversion.RebuiltTables, table)
+		}
+	}
+
+	// in reverse, so e.g. gpkg_extensions is dropped after the tables registered in it
+	for i := len(artifacts) - 1; i >= 0; i-- {
+		if artifacts[i].Kind == ArtifactTable {
+			if err = revertTable(ctx, artifacts[i].Name, db); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the progress of an interrupted run is removed as well
+	for _, table := range []string{progressTable, runsTable, artifactsTable} {
+		if err = execRevert(ctx, "", fmt.Sprintf("DROP TABLE IF EXISTS %s", table), db); err != nil {
+			return nil, err
+		}
+	}
+	if err = checkForeignKeys(ctx, db); err != nil {
+		return nil, err
+	}
+	reversion.Artifacts = artifacts
+	reversion.Runs = append(reversion.Runs, runs...)
+	return reversion, nil
+}
+
+// revertIndex drops the index, and recreates the index it replaced if any
+func revertIndex(ctx context.Context, index Artifact, db dbtx) error {
+	if err := execRevert(ctx, index.Table, fmt.Sprintf("DROP INDEX IF EXISTS %s", quoteIdentifier(index.Name)), db); err != nil {
+		return err
+	}
+	if index.definition == "" {
+		return nil
+	}
+	return execRevert(ctx, index.Table, index.definition, db)
+}
+
+// revertColumns drops the given columns from the table. When a column can't be dropped, e.g. because SQLite is older
+// than 3.35 or the column is used elsewhere in the schema, the table is rebuilt instead. Reports whether the table
+// was rebuilt.
+func revertColumns(ctx context.Context, tableName string, columns []Artifact, db dbtx) (bool, error) {
+	for _, column := range columns {
+		exists, err := columnExists(ctx, tableName, column.Name, db)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", quoteIdentifier(tableName), quoteIdentifier(column.Name))
+		slog.Debug("executing query", "table", tableName, "query", query)
+		if _, err = db.ExecContext(ctx, query); err != nil {
+			slog.Info("column can't be dropped, rebuilding table", "table", tableName, "column", column.Name, "error", err)
+			// the first column recorded has the SQL of the table before any column was added
+			return true, rebuildTable(ctx, tableName, columns[0].definition, db)
+		}
+	}
+	return false, nil
+}
+
+// rebuildTable recreates the table with the given SQL, keeping the rows and the indexes and triggers on the table.
+// The table is renamed first, so the SQL can be used as-is.
+func rebuildTable(ctx context.Context, tableName string, tableSQL string, db dbtx) error {
+	if tableSQL == "" {
+		return newError(StepRevert, tableName, "", fmt.Errorf("original SQL of the table isn't recorded"))
+	}
+	rows, err := db.QueryContext(ctx,
+		"SELECT sql FROM sqlite_master WHERE type IN ('index', 'trigger') AND lower(tbl_name) = lower(?) AND sql IS NOT NULL ORDER BY type",
+		tableName)
+	if err != nil {
+		return newError(StepRevert, tableName, "", err)
+	}
+	var dependents []string
+	for rows.Next() {
+		var definition string
+		if err = rows.Scan(&definition); err != nil {
+			rows.Close()
+			return newError(StepRevert, tableName, "", err)
+		}
+		dependents = append(dependents, definition)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return newError(StepRevert, tableName, "", err)
+	}
+
+	// legacy_alter_table keeps the rename from rewriting the references to the table in other tables, views and triggers
+	tempTable := tableName + "_pdok_revert"
+	queries := []string{
+		"PRAGMA legacy_alter_table = ON",
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdentifier(tableName), quoteIdentifier(tempTable)),
+		"PRAGMA legacy_alter_table = OFF",
+	}
+	for _, query := range queries {
+		if err = execRevert(ctx, tableName, query, db); err != nil {
+			return err
+		}
+	}
+	if err = execRevert(ctx, tableName, tableSQL, db); err != nil {
+		return err
+	}
+	var columnNames []string
+	columns, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", tableName)
+	if err != nil {
+		return newError(StepRevert, tableName, "", err)
+	}
+	for columns.Next() {
+		var name string
+		if err = columns.Scan(&name); err != nil {
+			columns.Close()
+			return newError(StepRevert, tableName, "", err)
+		}
+		columnNames = append(columnNames, name)
+	}
+	columns.Close()
+	if err = columns.Err(); err != nil {
+		return newError(StepRevert, tableName, "", err)
+	}
+
+	queries = []string{
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %[2]s FROM %s", quoteIdentifier(tableName), quoteIdentifiers(columnNames), quoteIdentifier(tempTable)),
+		fmt.Sprintf("DROP TABLE %s", quoteIdentifier(tempTable)),
+	}
+	for _, query := range append(queries, dependents...) {
+		if err = execRevert(ctx, tableName, query, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revertTable drops the table together with its registration in the Related Tables extension
+func revertTable(ctx context.Context, tableName string, db dbtx) error {
+	if err := execRevert(ctx, tableName, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(tableName)), db); err != nil {
+		return err
+	}
+	for _, registration := range []struct{ table, column string }{
+		{"gpkgext_relations", "mapping_table_name"},
+		{"gpkg_extensions", "table_name"},
+	} {
+		exists, err := tableExists(ctx, registration.table, db)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE lower(%s) = lower('%s')", registration.table, registration.column, strings.ReplaceAll(tableName, "'", "''"))
+		if err = execRevert(ctx, tableName, query, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkForeignKeys fails when rows violate a foreign key, which aren't enforced while reverting
+func checkForeignKeys(ctx context.Context, db dbtx) error {
+	var table string
+	var rowid sql.NullInt64
+	var parent string
+	var fkid int
+	err := db.QueryRowContext(ctx, "PRAGMA foreign_key_check").Scan(&table, &rowid, &parent, &fkid)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return newError(StepRevert, "", "", fmt.Errorf("error checking foreign keys: %w", err))
+	}
+	return newError(StepRevert, table, "", fmt.Errorf("row %d violates its foreign key to table '%s'", rowid.Int64, parent))
+}
+
+func execRevert(ctx context.Context, tableName string, query string, db dbtx) error {
+	slog.Debug("executing query", "table", tableName, "query", query)
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return newError(StepRevert, tableName, "", fmt.Errorf("error executing query '%s': %w", query, err))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestRevertOWS(t *testing.T) {
+	config, err := ParseOwsConfig([]byte(`{"indices":[{"name":"layer_fid_idx","table":"layer","columns":["fid","puuid"]}]}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	if err = OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", config, WithOutput(outputGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	// re-runs don't record the artifacts again
+	if err = OptimizeOWSFile(context.Background(), outputGeopackage, config); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	reversion, err := RevertFile(context.Background(), outputGeopackage)
+	if err != nil {
+		t.Fatalf("error reverting GeoPackage: %s", err)
+	}
+	if len(reversion.Artifacts) != 11 || len(reversion.RebuiltTables) != 0 || len(reversion.Runs) != 2 {
+		t.Fatalf("expected the puuid and fuuid columns, their indexes, the configured index and the provenance of both runs to be reverted, got:\n%s", reversion)
+	}
+	diff, err := DiffFiles(context.Background(), "../geopackage/original_ows.gpkg", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error comparing GeoPackages: %s", err)
+	}
+	if !diff.Identical() {
+		t.Fatalf("expected the reverted GeoPackage to be identical to the original:\n%s", diff)
+	}
+
+	reversion, err = RevertFile(context.Background(), outputGeopackage)
+	if err != nil {
+		t.Fatalf("error reverting GeoPackage: %s", err)
+	}
+	if len(reversion.Artifacts) != 0 {
+		t.Fatalf("expected nothing to revert, got:\n%s", reversion)
+	}
+}
+
+func TestRevertRebuildsTable(t *testing.T) {
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	if err := OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", nil, WithOutput(outputGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	// a column used in a view can't be dropped
+	if _, err = db.Exec("CREATE VIEW layer_fuuids AS SELECT fid, fuuid FROM layer"); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	db.Close()
+
+	reversion, err := RevertFile(context.Background(), outputGeopackage)
+	if err != nil {
+		t.Fatalf("error reverting GeoPackage: %s", err)
+	}
+	// once the view is broken other tables can't drop columns either, so these are rebuilt as well
+	if len(reversion.RebuiltTables) == 0 || reversion.RebuiltTables[0] != "layer" {
+		t.Fatalf("expected table 'layer' to be rebuilt, got:\n%s", reversion)
+	}
+	diff, err := DiffFiles(context.Background(), "../geopackage/original_ows.gpkg", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error comparing GeoPackages: %s", err)
+	}
+	if len(diff.Changes) != 1 || diff.Changes[0].Name != "layer_fuuids" {
+		t.Fatalf("expected only the view to differ from the original:\n%s", diff)
+	}
+}
@@ -0,0 +1,157 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// indexDefinition describes an existing index as found in the GeoPackage
+type indexDefinition struct {
+	Table   string
+	Columns []string
+	Unique  bool
+}
+
+// matches reports whether the index is defined on the given table and columns (in order) with the given uniqueness
+func (i *indexDefinition) matches(tableName string, columnNames []string, unique bool) bool {
+	if !strings.EqualFold(i.Table, tableName) || i.Unique != unique || len(i.Columns) != len(columnNames) {
+		return false
+	}
+	for n, columnName := range columnNames {
+		if !strings.EqualFold(i.Columns[n], strings.Trim(strings.TrimSpace(columnName), "\"'`")) {
+			return false
+		}
+	}
+	return true
+}
+
+func columnExists(ctx context.Context, tableName string, columnName string, db dbtx) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE lower(name) = lower(?))",
+		tableName, columnName).Scan(&exists)
+	if err != nil {
+		return false, newError(StepInspectSchema, tableName, columnName, err)
+	}
+	return exists, nil
+}
+
+// getColumnType returns the declared type of the given column in lower case, or an empty string when it has none
+func getColumnType(ctx context.Context, tableName string, columnName string, db dbtx) (string, error) {
+	var columnType string
+	err := db.QueryRowContext(ctx,
+		"SELECT lower(type) FROM pragma_table_info(?) WHERE lower(name) = lower(?)",
+		tableName, columnName).Scan(&columnType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", newError(StepInspectSchema, tableName, columnName, err)
+	}
+	return columnType, nil
+}
+
+// getIndex returns the definition of the index with the given name, or nil when it doesn't exist
+func getIndex(ctx context.Context, indexName string, db dbtx) (*indexDefinition, error) {
+	var index indexDefinition
+	err := db.QueryRowContext(ctx,
+		"SELECT tbl_name FROM sqlite_master WHERE type = 'index' AND lower(name) = lower(?)",
+		indexName).Scan(&index.Table)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, newError(StepInspectSchema, "", "", fmt.Errorf("error selecting index '%s': %w", indexName, err))
+	}
+
+	err = db.QueryRowContext(ctx,
+		"SELECT \"unique\" FROM pragma_index_list(?) WHERE lower(name) = lower(?)",
+		index.Table, indexName).Scan(&index.Unique)
+	if err != nil {
+		return nil, newError(StepInspectSchema, index.Table, "", fmt.Errorf("error selecting index list for '%s': %w", indexName, err))
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", indexName)
+	if err != nil {
+		return nil, newError(StepInspectSchema, index.Table, "", fmt.Errorf("error selecting index info for '%s': %w", indexName, err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var columnName sql.NullString // NULL for expressions
+		if err = rows.Scan(&columnName); err != nil {
+			return nil, newError(StepInspectSchema, index.Table, "", err)
+		}
+		index.Columns = append(index.Columns, columnName.String)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, newError(StepInspectSchema, index.Table, "", err)
+	}
+	return &index, nil
+}
+
+func tableExists(ctx context.Context, tableName string, db dbtx) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type IN ('table', 'view') AND lower(name) = lower(?))",
+		tableName).Scan(&exists)
+	if err != nil {
+		return false, newError(StepInspectSchema, tableName, "", err)
+	}
+	return exists, nil
+}
+
+// getPrimaryKeyColumn returns the primary key column of the given table, or an empty string
+// when the table has no (single column) primary key
+func getPrimaryKeyColumn(ctx context.Context, tableName string, db dbtx) (string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?) WHERE pk > 0", tableName)
+	if err != nil {
+		return "", newError(StepInspectSchema, tableName, "", err)
+	}
+	defer rows.Close()
+
+	var pkColumns []string
+	for rows.Next() {
+		var columnName string
+		if err = rows.Scan(&columnName); err != nil {
+			return "", newError(StepInspectSchema, tableName, "", err)
+		}
+		pkColumns = append(pkColumns, columnName)
+	}
+	if err = rows.Err(); err != nil {
+		return "", newError(StepInspectSchema, tableName, "", err)
+	}
+	if len(pkColumns) != 1 {
+		return "", nil
+	}
+	return pkColumns[0], nil
+}
+
+// getIntegerPrimaryKeyColumn returns the INTEGER PRIMARY KEY (rowid alias) column of the given table,
+// or an empty string when the table has no such column
+func getIntegerPrimaryKeyColumn(ctx context.Context, tableName string, db dbtx) (string, error) {
+	var columnName string
+	err := db.QueryRowContext(ctx,
+		`SELECT name FROM pragma_table_info(?) WHERE pk = 1 AND upper(type) = 'INTEGER'
+			AND (SELECT count(*) FROM pragma_table_info(?) WHERE pk > 0) = 1`,
+		tableName, tableName).Scan(&columnName)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", newError(StepInspectSchema, tableName, "", err)
+	}
+	return columnName, nil
+}
+
+// getDataType returns the data_type of the given table in gpkg_contents, or an empty string when the table isn't registered
+func getDataType(ctx context.Context, tableName string, db dbtx) (string, error) {
+	var dataType string
+	err := db.QueryRowContext(ctx,
+		"SELECT data_type FROM gpkg_contents WHERE lower(table_name) = lower(?)",
+		tableName).Scan(&dataType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", newError(StepInspectSchema, tableName, "", err)
+	}
+	return dataType, nil
+}
@@ -0,0 +1,223 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SpatiaLitePathEnv is the environment variable pointing at the SpatiaLite extension, see SetSpatiaLitePath
+const SpatiaLitePathEnv = "SPATIALITE_PATH"
+
+// spatialiteExtension is the name SQLite resolves through the library search path of the platform,
+// used when the extension isn't configured and not found at one of the spatialiteLocations
+const spatialiteExtension = "mod_spatialite"
+
+var spatialite = struct {
+	mu sync.Mutex
+	// path configured with SetSpatiaLitePath
+	path string
+	// loaded is the extension loaded by the last connection, err why loading failed
+	loaded string
+	err    error
+}{}
+
+// SetSpatiaLitePath sets the path of the SpatiaLite extension loaded by connections opened afterwards. Without it
+// the path in the SPATIALITE_PATH environment variable is used, and otherwise the well-known library locations of the
+// platform are searched.
+func SetSpatiaLitePath(path string) {
+	spatialite.mu.Lock()
+	defer spatialite.mu.Unlock()
+	spatialite.path = path
+}
+
+// spatialiteCandidates returns the extensions to try in order: the configured path, or the first existing well-known
+// location followed by the bare extension name
+func spatialiteCandidates() []string {
+	spatialite.mu.Lock()
+	path := spatialite.path
+	spatialite.mu.Unlock()
+	if path == "" {
+		path = os.Getenv(SpatiaLitePathEnv)
+	}
+	if path != "" {
+		return []string{path}
+	}
+	for _, location := range spatialiteLocations() {
+		if _, err := os.Stat(location); err == nil {
+			return []string{location, spatialiteExtension}
+		}
+	}
+	return []string{spatialiteExtension}
+}
+
+// loadSpatiaLite loads the SpatiaLite extension on the given connection and reports whether it succeeded.
+// A missing extension doesn't prevent opening the GeoPackage, Go implemented fallbacks are registered instead.
+func loadSpatiaLite(conn *sqlite3.SQLiteConn) bool {
+	var errs []error
+	for _, extension := range spatialiteCandidates() {
+		err := conn.LoadExtension(extension, extensionEntryPoint(extension))
+		if err == nil {
+			setSpatiaLiteStatus(extension, nil)
+			return true
+		}
+		errs = append(errs, fmt.Errorf("'%s': %w", extension, err))
+	}
+	setSpatiaLiteStatus("", errors.Join(errs...))
+	return false
+}
+
+func setSpatiaLiteStatus(loaded string, err error) {
+	spatialite.mu.Lock()
+	defer spatialite.mu.Unlock()
+	spatialite.loaded, spatialite.err = loaded, err
+}
+
+// probedFunctions are the spatial SQL functions of which the availability is reported by ProbeCapabilities
+var probedFunctions = []string{
+	"ST_MinX", "ST_MaxX", "ST_MinY", "ST_MaxY", "ST_IsEmpty",
+	"ST_GeomFromGPB", "ST_Envelope", "ST_IsValid", "ST_Transform",
+	"pdok_minx", "pdok_maxx", "pdok_miny", "pdok_maxy",
+	"pdok_uuid4", "pdok_uuid5", "pdok_external_fid",
+}
+
+// Capabilities describes the SQLite build and the spatial SQL functions available to the optimizer
+type Capabilities struct {
+	SQLiteVersion     string `json:"sqliteVersion"`
+	SpatiaLiteVersion string `json:"spatialiteVersion,omitempty"`
+	// SpatiaLitePath is the extension that was loaded
+	SpatiaLitePath string `json:"spatialitePath,omitempty"`
+	// SpatiaLiteError explains why SpatiaLite couldn't be loaded
+	SpatiaLiteError string          `json:"spatialiteError,omitempty"`
+	Functions       map[string]bool `json:"functions"`
+}
+
+// SpatiaLite reports whether the SpatiaLite extension is loaded
+func (c *Capabilities) SpatiaLite() bool {
+	return c.SpatiaLiteVersion != ""
+}
+
+// String renders the capabilities as human-readable text
+func (c *Capabilities) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "SQLite %s\n", c.SQLiteVersion)
+	if c.SpatiaLite() {
+		fmt.Fprintf(&sb, "SpatiaLite %s (%s)\n", c.SpatiaLiteVersion, c.SpatiaLitePath)
+	} else {
+		fmt.Fprintf(&sb, "SpatiaLite not loaded: %s\n", c.SpatiaLiteError)
+	}
+	names := make([]string, 0, len(c.Functions))
+	for name := range c.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status := "available"
+		if !c.Functions[name] {
+			status = "missing"
+		}
+		fmt.Fprintf(&sb, "  %s: %s\n", name, status)
+	}
+	return sb.String()
+}
+
+// ProbeCapabilities opens an in-memory database the same way GeoPackages are opened and reports the SQLite and
+// SpatiaLite versions, and which spatial SQL functions are available
+func ProbeCapabilities(ctx context.Context) (*Capabilities, error) {
+	db, err := OpenDB(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return probeCapabilities(ctx, db)
+}
+
+func probeCapabilities(ctx context.Context, db *sql.DB) (*Capabilities, error) {
+	// the status is per connection, so use a single one for loading and probing
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, newError(StepOpen, "", "", err)
+	}
+	defer conn.Close()
+
+	c := &Capabilities{Functions: make(map[string]bool)}
+	if err = conn.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&c.SQLiteVersion); err != nil {
+		return nil, newError(StepOpen, "", "", fmt.Errorf("error getting SQLite version: %w", err))
+	}
+	if conn.QueryRowContext(ctx, "SELECT spatialite_version()").Scan(&c.SpatiaLiteVersion) != nil {
+		c.SpatiaLiteVersion = ""
+	}
+	spatialite.mu.Lock()
+	if c.SpatiaLite() {
+		c.SpatiaLitePath = spatialite.loaded
+	} else if spatialite.err != nil {
+		c.SpatiaLiteError = spatialite.err.Error()
+	}
+	spatialite.mu.Unlock()
+
+	for _, name := range probedFunctions {
+		c.Functions[name] = functionAvailable(ctx, conn, name)
+	}
+	return c, nil
+}
+
+// functionAvailable reports whether the SQL function exists, preparing a call fails with 'no such function' otherwise
+func functionAvailable(ctx context.Context, conn *sql.Conn, name string) bool {
+	stmt, err := conn.PrepareContext(ctx, fmt.Sprintf("SELECT %s(NULL)", name))
+	if err != nil {
+		return !strings.Contains(err.Error(), "no such function")
+	}
+	stmt.Close()
+	return true
+}
+
+// requirement lists the SQL functions an optimization depends on
+type requirement struct {
+	optimization string
+	functions    []string
+}
+
+var (
+	// owsRequirements is the function filling random puuids in a single pass, the other puuids are generated in Go
+	owsRequirements = requirement{optimization: "OWS", functions: []string{"pdok_uuid4"}}
+	// oafRequirements are the envelope functions, and the functions used by the rtree triggers GDAL creates which
+	// fire when the envelope columns are updated. SpatiaLite or the Go implemented fallbacks provide these.
+	oafRequirements = requirement{optimization: "OAF", functions: []string{
+		"pdok_minx", "pdok_maxx", "pdok_miny", "pdok_maxy", "pdok_external_fid",
+		"ST_MinX", "ST_MaxX", "ST_MinY", "ST_MaxY", "ST_IsEmpty",
+	}}
+)
+
+// check returns an error naming the functions the optimization requires that aren't available
+func (r requirement) check(c *Capabilities) error {
+	var missing []string
+	for _, function := range r.functions {
+		if !c.Functions[function] {
+			missing = append(missing, function)
+		}
+	}
+	if len(missing) > 0 {
+		return newError(StepCheckRequirements, "", "", fmt.Errorf("%s optimizations require SQL functions %v, which aren't available", r.optimization, missing))
+	}
+	return nil
+}
+
+// checkRequirements probes the capabilities of the given database and checks the requirements of an optimization
+// against them. When SpatiaLite is required it has to be loaded as well.
+func checkRequirements(ctx context.Context, db *sql.DB, r requirement, o *options) error {
+	c, err := probeCapabilities(ctx, db)
+	if err != nil {
+		return err
+	}
+	if o.requireSpatiaLite && !c.SpatiaLite() {
+		return newError(StepCheckRequirements, "", "", fmt.Errorf("SpatiaLite is required but couldn't be loaded: %s", c.SpatiaLiteError))
+	}
+	return r.check(c)
+}
@@ -0,0 +1,48 @@
+package optimizer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestProbeCapabilities(t *testing.T) {
+	capabilities, err := ProbeCapabilities(context.Background())
+	if err != nil {
+		t.Fatalf("error probing capabilities: %s", err)
+	}
+	if capabilities.SQLiteVersion == "" {
+		t.Fatalf("expected SQLite version")
+	}
+	// available either through SpatiaLite or through the Go implemented fallbacks
+	for _, function := range oafRequirements.functions {
+		if !capabilities.Functions[function] {
+			t.Fatalf("expected function '%s' to be available:\n%s", function, capabilities)
+		}
+	}
+	if capabilities.SpatiaLite() == (capabilities.SpatiaLitePath == "") {
+		t.Fatalf("expected the path of the loaded SpatiaLite extension:\n%s", capabilities)
+	}
+	if !capabilities.SpatiaLite() && capabilities.SpatiaLiteError == "" {
+		t.Fatalf("expected the reason SpatiaLite couldn't be loaded:\n%s", capabilities)
+	}
+}
+
+func TestOptimizeOAFGeopackageRequireSpatiaLite(t *testing.T) {
+	SetSpatiaLitePath(filepath.Join(t.TempDir(), "mod_spatialite.so"))
+	t.Cleanup(func() { SetSpatiaLitePath("") })
+
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	err := OptimizeOAFFile(context.Background(), "../geopackage/original_ows.gpkg", nil,
+		WithOutput(outputGeopackage), WithRequireSpatiaLite())
+	var optimizerErr *Error
+	if !errors.As(err, &optimizerErr) || optimizerErr.Step != StepCheckRequirements {
+		t.Fatalf("expected error checking requirements, got: %v", err)
+	}
+
+	// without requiring SpatiaLite the Go implemented fallbacks are used
+	if err = OptimizeOAFFile(context.Background(), "../geopackage/original_ows.gpkg", nil, WithOutput(outputGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+}
@@ -0,0 +1,84 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// TransactionScope determines which changes are grouped in a single transaction
+type TransactionScope string
+
+const (
+	// TransactionScopeRun wraps all changes of a run in one transaction, a failure leaves the GeoPackage untouched
+	TransactionScopeRun TransactionScope = "run"
+	// TransactionScopeTable wraps the changes per table in a transaction, a failure only rolls back the failing table
+	TransactionScopeTable TransactionScope = "table"
+	// TransactionScopeChunk commits schema changes immediately and data changes per chunk of rows, an interrupted
+	// run can be resumed by re-running the optimizer. Meant for very large tables, a failure leaves the GeoPackage
+	// partially optimized.
+	TransactionScopeChunk TransactionScope = "chunk"
+)
+
+// dbtx is implemented by both *sql.DB and *sql.Tx
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// unitOfWork is a group of changes to a table that should either all be applied or not at all
+type unitOfWork struct {
+	table string
+	apply func(db dbtx) error
+}
+
+// transactional performs the units of work in a single transaction, in a transaction per unit or in a transaction per
+// chunk, depending on the scope. Except with a transaction per unit, the tables are optimized concurrently by the
+// configured number of workers. Once all units succeeded finish is called, in the transaction of the run or, when
+// committing per unit or per chunk, in a transaction of its own.
+func transactional(ctx context.Context, db *sql.DB, o *options, units []unitOfWork, finish func(db dbtx) error) error {
+	switch o.transactionScope {
+	case TransactionScopeRun:
+		return withTransaction(ctx, db, func(tx *sql.Tx) error {
+			if err := applyUnits(tx, o, units); err != nil {
+				return err
+			}
+			return finish(tx)
+		})
+	case TransactionScopeTable:
+		for _, unit := range units {
+			if err := withTransaction(ctx, db, func(tx *sql.Tx) error { return applyUnits(tx, o, []unitOfWork{unit}) }); err != nil {
+				return err
+			}
+		}
+	case TransactionScopeChunk:
+		if err := applyUnits(db, o, units); err != nil {
+			return err
+		}
+	default:
+		return newError(StepConfig, "", "", fmt.Errorf("invalid transaction scope: '%s'", o.transactionScope))
+	}
+	return withTransaction(ctx, db, func(tx *sql.Tx) error { return finish(tx) })
+}
+
+// withTransaction commits the changes made by fn when it succeeds and rolls them back otherwise
+func withTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return newError(StepTransaction, "", "", fmt.Errorf("error beginning transaction: %w", err))
+	}
+	if err = fn(tx); err != nil {
+		slog.Error("rolling back transaction", "error", err)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			slog.Error("error rolling back transaction", "error", rollbackErr)
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return newError(StepTransaction, "", "", fmt.Errorf("error committing transaction: %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultChunkSize is the number of rows read, computed and written at a time by updateRows
+const defaultChunkSize = 10000
+
+// progressTable keeps track of the last committed chunk per update when committing per chunk,
+// so an interrupted run resumes where it left off. It's dropped once all updates are finished.
+const progressTable = "pdok_optimizer_progress"
+
+// rowUpdate sets columns of a table to values computed in Go, row by row
+type rowUpdate struct {
+	table string
+	// step the update is reported as
+	step Step
+	// columns to set
+	columns []string
+	// selectColumns are the (quoted) expressions passed to compute
+	selectColumns []string
+	// where optionally restricts the rows to update
+	where string
+	// description of how the values are computed, used in the plan
+	description string
+	// resumable updates continue after the last committed chunk when committing per chunk. Updates
+	// that need to see every row (e.g. to detect duplicates) should restrict the rows with where instead.
+	resumable bool
+	// compute returns the values of columns for a row, or nil to leave the row as-is.
+	// It's called concurrently, when multiple workers are configured.
+	compute func(values []any) ([]any, error)
+}
+
+// updateRows performs the update in chunks of rows ordered by rowid. The values of a chunk are computed by
+// the configured number of workers, after which the chunk is written. With TransactionScopeChunk every chunk
+// is committed separately. Returns the number of updated rows.
+func updateRows(ctx context.Context, db dbtx, u rowUpdate) (int, error) {
+	assignments := make([]string, len(u.columns))
+	for i, column := range u.columns {
+		assignments[i] = quoteIdentifier(column) + " = ?"
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s WHERE rowid = ?", quoteIdentifier(u.table), strings.Join(assignments, ", "))
+	if p, ok := db.(*planner); ok {
+		p.planPerRow(updateQuery, u.description)
+		return 0, nil
+	}
+
+	selectQuery := fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid > ?", strings.Join(u.selectColumns, ", "), quoteIdentifier(u.table))
+	if u.where != "" {
+		selectQuery += fmt.Sprintf(" AND (%s)", u.where)
+	}
+	selectQuery += " ORDER BY rowid LIMIT ?"
+
+	stmt, err := db.PrepareContext(ctx, updateQuery)
+	if err != nil {
+		return 0, fmt.Errorf("error preparing update statement: %w", err)
+	}
+	defer stmt.Close()
+
+	o := runOptions(db)
+	chunkDB := committingPerChunk(db)
+	step := strings.Join(u.columns, ",")
+	lastRowid := int64(math.MinInt64)
+	if chunkDB != nil && u.resumable {
+		rowid, found, err := getProgress(ctx, u.table, step, db)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			slog.Info("resuming update", "table", u.table, "column", step, "rowid", rowid)
+			lastRowid = rowid
+		}
+	}
+
+	start := time.Now()
+	updated := 0
+	for {
+		rowids, chunk, err := readChunk(ctx, db, selectQuery, lastRowid, o.chunkSize, len(u.selectColumns))
+		if err != nil {
+			return updated, err
+		}
+		if len(rowids) == 0 {
+			break
+		}
+		lastRowid = rowids[len(rowids)-1]
+
+		var results [][]any
+		withoutLock(db, func() {
+			results, err = computeChunk(o.workers, chunk, u.compute)
+		})
+		if err != nil {
+			return updated, err
+		}
+
+		var n int
+		if chunkDB == nil {
+			n, err = writeChunk(ctx, stmt, rowids, results)
+		} else {
+			n, err = commitChunk(ctx, chunkDB, stmt, rowids, results, func(tx *sql.Tx) error {
+				if !u.resumable {
+					return nil
+				}
+				return setProgress(ctx, u.table, step, lastRowid, tx)
+			})
+		}
+		updated += n
+		if err != nil {
+			return updated, err
+		}
+	}
+
+	recordStep(db, u.table, u.step, step, int64(updated), start)
+	if chunkDB != nil && u.resumable {
+		return updated, clearProgress(ctx, u.table, step, db)
+	}
+	return updated, nil
+}
+
+// committingPerChunk returns the database to begin a transaction per chunk on, or nil when the
+// update is part of a larger transaction
+func committingPerChunk(db dbtx) *sql.DB {
+	if c, ok := db.(*concurrentTx); ok {
+		db = c.dbtx
+	}
+	sqlDB, _ := db.(*sql.DB)
+	return sqlDB
+}
+
+// readChunk reads the next chunk of rows, the cursor is closed before the chunk is written
+func readChunk(ctx context.Context, db dbtx, query string, lastRowid int64, chunkSize int, columns int) ([]int64, [][]any, error) {
+	rows, err := db.QueryContext(ctx, query, lastRowid, chunkSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error selecting rows: %w", err)
+	}
+	defer rows.Close()
+
+	var rowids []int64
+	var chunk [][]any
+	for rows.Next() {
+		var rowid int64
+		values, scanArgs := keyScanArgs(columns)
+		if err = rows.Scan(append([]any{&rowid}, scanArgs...)...); err != nil {
+			return nil, nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		rowids = append(rowids, rowid)
+		chunk = append(chunk, values)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return rowids, chunk, nil
+}
+
+// computeChunk divides the rows of the chunk over the workers and returns the computed values in order
+func computeChunk(workers int, chunk [][]any, compute func([]any) ([]any, error)) ([][]any, error) {
+	results := make([][]any, len(chunk))
+	size := (len(chunk) + workers - 1) / workers
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := w*size, min((w+1)*size, len(chunk))
+		if start >= end {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				values, err := compute(chunk[i])
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				results[i] = values
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func writeChunk(ctx context.Context, stmt *sql.Stmt, rowids []int64, results [][]any) (int, error) {
+	updated := 0
+	for i, values := range results {
+		if values == nil {
+			continue
+		}
+		if _, err := stmt.ExecContext(ctx, append(values, rowids[i])...); err != nil {
+			return updated, fmt.Errorf("error updating row %d: %w", rowids[i], err)
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// commitChunk writes the chunk in its own transaction, together with the progress recorded by afterWrite
+func commitChunk(ctx context.Context, db *sql.DB, stmt *sql.Stmt, rowids []int64, results [][]any, afterWrite func(tx *sql.Tx) error) (int, error) {
+	updated := 0
+	err := withTransaction(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		if updated, err = writeChunk(ctx, tx.StmtContext(ctx, stmt), rowids, results); err != nil {
+			return err
+		}
+		return afterWrite(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func getProgress(ctx context.Context, tableName string, step string, db dbtx) (int64, bool, error) {
+	exists, err := tableExists(ctx, progressTable, db)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	var rowid int64
+	err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT last_rowid FROM %s WHERE table_name = ? AND step = ?", progressTable),
+		tableName, step).Scan(&rowid)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error reading progress: %w", err)
+	}
+	return rowid, true, nil
+}
+
+func setProgress(ctx context.Context, tableName string, step string, lastRowid int64, db dbtx) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		table_name TEXT NOT NULL, step TEXT NOT NULL, last_rowid INTEGER NOT NULL, PRIMARY KEY (table_name, step))`, progressTable))
+	if err != nil {
+		return fmt.Errorf("error creating progress table: %w", err)
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO %s (table_name, step, last_rowid) VALUES (?, ?, ?)", progressTable),
+		tableName, step, lastRowid)
+	if err != nil {
+		return fmt.Errorf("error recording progress: %w", err)
+	}
+	return nil
+}
+
+// clearProgress removes the progress of a finished update, and the progress table once it's empty
+func clearProgress(ctx context.Context, tableName string, step string, db dbtx) error {
+	exists, err := tableExists(ctx, progressTable, db)
+	if err != nil || !exists {
+		return err
+	}
+	if _, err = db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE table_name = ? AND step = ?", progressTable), tableName, step); err != nil {
+		return fmt.Errorf("error clearing progress: %w", err)
+	}
+	var remaining int
+	if err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", progressTable)).Scan(&remaining); err != nil {
+		return fmt.Errorf("error clearing progress: %w", err)
+	}
+	if remaining == 0 {
+		if _, err = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", progressTable)); err != nil {
+			return fmt.Errorf("error dropping progress table: %w", err)
+		}
+	}
+	return nil
+}
+
+// withoutLock releases the database to other units of work while fn runs
+func withoutLock(db dbtx, fn func()) {
+	c, ok := db.(*concurrentTx)
+	if !ok {
+		fn()
+		return
+	}
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	fn()
+}
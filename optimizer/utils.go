@@ -0,0 +1,300 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	// Basic platform-agnostic initialization
+	// The platform-specific initialization is in platform_*.go files
+}
+
+const driverName = "sqlite3_with_extensions"
+
+var preloadOnce sync.Once
+
+func registerDriver() {
+	for _, driver := range sql.Drivers() {
+		if driver == driverName {
+			return
+		}
+	}
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// This is a hook that runs when a new connection is established.
+			// SpatiaLite is loaded here (instead of through the driver) so a missing
+			// extension doesn't prevent opening the GeoPackage, the Go implemented
+			// functions are used as fallback for the spatial functions instead.
+			return registerFunctions(conn, loadSpatiaLite(conn))
+		},
+	})
+}
+
+// extensionEntryPoint derives the entry point of the extension the same way SQLite does,
+// e.g. 'sqlite3_modspatialite_init' for 'mod_spatialite'
+func extensionEntryPoint(extension string) string {
+	name := filepath.Base(extension)
+	name = strings.TrimPrefix(name, "lib")
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	var sb strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) {
+			sb.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return fmt.Sprintf("sqlite3_%s_init", sb.String())
+}
+
+// OpenDB opens the given GeoPackage using a SQLite driver with the SpatiaLite extension registered,
+// see SetSpatiaLitePath for where the extension is looked for
+func OpenDB(sourceGeopackage string) (*sql.DB, error) {
+	// The platform-specific preloadDependencies implementation is in platform_*.go files
+	preloadOnce.Do(preloadDependencies)
+	registerDriver()
+
+	db, err := sql.Open(driverName, fmt.Sprintf("file:%s?_load_extension=1&_sqlite_extensions=1", sourceGeopackage))
+	if err != nil {
+		return nil, newError(StepOpen, "", "", err)
+	}
+	db.Exec("PRAGMA foreign_keys = ON;")
+	db.Exec("PRAGMA trusted_schema = 1;")
+
+	c, err := probeCapabilities(context.Background(), db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	slog.Debug("SQLite loaded", "version", c.SQLiteVersion)
+	if c.SpatiaLite() {
+		slog.Debug("SpatiaLite loaded", "version", c.SpatiaLiteVersion, "path", c.SpatiaLitePath)
+	} else {
+		slog.Warn("could not load SpatiaLite extension, continuing with Go implementations of ST_MinX, ST_MaxX, ST_MinY, ST_MaxY and ST_IsEmpty",
+			"error", c.SpatiaLiteError)
+	}
+	return db, nil
+}
+
+// GeoPackage data types, see http://www.geopackage.org/spec/#_contents
+const (
+	dataTypeFeatures   = "features"
+	dataTypeAttributes = "attributes"
+	dataTypeTiles      = "tiles"
+)
+
+// gpkgTable is a table registered in gpkg_contents
+type gpkgTable struct {
+	Name     string
+	DataType string
+	// GeometryColumn as registered in gpkg_geometry_columns, only set for feature tables
+	GeometryColumn string
+}
+
+func getTables(ctx context.Context, db dbtx) ([]gpkgTable, error) {
+	hasGeometryColumns, err := tableExists(ctx, "gpkg_geometry_columns", db)
+	if err != nil {
+		return nil, err
+	}
+	query := "select table_name, data_type, null from gpkg_contents"
+	if hasGeometryColumns {
+		query = `select c.table_name, c.data_type, g.column_name from gpkg_contents c
+			left join gpkg_geometry_columns g on lower(g.table_name) = lower(c.table_name)`
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, newError(StepListTables, "", "", fmt.Errorf("error selecting gpkg_contents: %w", err))
+	}
+	defer rows.Close()
+
+	var tables []gpkgTable
+
+	for rows.Next() {
+		var table gpkgTable
+		var geometryColumn sql.NullString
+		err = rows.Scan(&table.Name, &table.DataType, &geometryColumn)
+		if err != nil {
+			return nil, newError(StepListTables, "", "", err)
+		}
+		table.GeometryColumn = geometryColumn.String
+		tables = append(tables, table)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, newError(StepListTables, "", "", err)
+	}
+
+	return tables, nil
+}
+
+// quoteIdentifier quotes the given table or column name for use in SQL
+func quoteIdentifier(name string) string {
+	return fmt.Sprintf("\"%s\"", strings.ReplaceAll(name, "\"", "\"\""))
+}
+
+// quoteIdentifiers quotes the given column names and joins them into a column list
+func quoteIdentifiers(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdentifier(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// createIndex creates the given index, unless an identical index already exists.
+// An existing index with the same name but a different definition is rebuilt.
+func createIndex(ctx context.Context, tableName string, columnNames []string, indexName string, unique bool, db dbtx) error {
+	if indexName == "" {
+		indexName = fmt.Sprintf("%s_%s_index", tableName, strings.Join(columnNames, "_"))
+	}
+
+	start := time.Now()
+	existing, err := getIndex(ctx, indexName, db)
+	if err != nil {
+		return err
+	}
+	artifact := Artifact{Kind: ArtifactIndex, Table: tableName, Name: indexName}
+	if existing != nil {
+		if existing.matches(tableName, columnNames, unique) {
+			slog.Debug("index already exists, skipping", "table", tableName, "index", indexName)
+			return nil
+		}
+		// revert restores the index replaced
+		if artifact.definition, err = getSchemaSQL(ctx, indexName, db); err != nil {
+			return err
+		}
+		query := fmt.Sprintf("DROP INDEX %s;", quoteIdentifier(indexName))
+		slog.Info("index differs from requested definition, rebuilding", "table", tableName, "index", indexName)
+		if _, err = db.ExecContext(ctx, query); err != nil {
+			return newError(StepCreateIndex, tableName, strings.Join(columnNames, ","), fmt.Errorf("error dropping index '%s': %w", indexName, err))
+		}
+	}
+
+	var queryStr string
+	if unique {
+		queryStr = "CREATE UNIQUE INDEX %s ON %s(%s);"
+	} else {
+		queryStr = "CREATE INDEX %s ON %s(%s);"
+	}
+
+	query := fmt.Sprintf(queryStr, quoteIdentifier(indexName), quoteIdentifier(tableName), quoteIdentifiers(columnNames))
+	slog.Debug("executing query", "table", tableName, "query", query)
+
+	_, err = db.ExecContext(ctx, query)
+	if err != nil {
+		return newError(StepCreateIndex, tableName, strings.Join(columnNames, ","), fmt.Errorf("error creating index '%s': %w", indexName, err))
+	}
+	if err = recordArtifact(ctx, artifact, db); err != nil {
+		return err
+	}
+	recordIndex(db, tableName, indexName, columnNames, unique)
+	recordStep(db, tableName, StepCreateIndex, strings.Join(columnNames, ","), 0, start)
+	return nil
+}
+
+// setColumnValues sets multiple columns in a single pass over the table
+func setColumnValues(ctx context.Context, tableName string, columnNames []string, values []string, db dbtx) error {
+	assignments := make([]string, len(columnNames))
+	for i, columnName := range columnNames {
+		assignments[i] = fmt.Sprintf("%s = %s", quoteIdentifier(columnName), values[i])
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s;", quoteIdentifier(tableName), strings.Join(assignments, ", "))
+	slog.Debug("executing query", "table", tableName, "query", query)
+
+	start := time.Now()
+	result, err := db.ExecContext(ctx, query)
+	if err != nil {
+		return newError(StepSetColumnValue, tableName, strings.Join(columnNames, ","), fmt.Errorf("error setting values '%s': %w", strings.Join(values, ", "), err))
+	}
+	recordStep(db, tableName, StepSetColumnValue, strings.Join(columnNames, ","), rowsAffected(result), start)
+	return nil
+}
+
+func setColumnValue(ctx context.Context, tableName string, columnName string, value string, db dbtx) error {
+	query := fmt.Sprintf("UPDATE %s SET %s = %s;", quoteIdentifier(tableName), quoteIdentifier(columnName), value)
+	slog.Debug("executing query", "table", tableName, "query", query)
+
+	start := time.Now()
+	result, err := db.ExecContext(ctx, query)
+	if err != nil {
+		return newError(StepSetColumnValue, tableName, columnName, fmt.Errorf("error setting value '%s': %w", value, err))
+	}
+	recordStep(db, tableName, StepSetColumnValue, columnName, rowsAffected(result), start)
+	return nil
+}
+
+// addColumn adds the given column, unless it already exists
+func addColumn(ctx context.Context, tableName string, columnName string, columnType string, db dbtx) error {
+	exists, err := columnExists(ctx, tableName, columnName, db)
+	if err != nil {
+		return err
+	}
+	if exists {
+		slog.Debug("column already exists, skipping", "table", tableName, "column", columnName)
+		return nil
+	}
+
+	// revert rebuilds the table with its original SQL when the column can't be dropped
+	tableSQL, err := getSchemaSQL(ctx, tableName, db)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("ALTER TABLE %s ADD %s %s;", quoteIdentifier(tableName), quoteIdentifier(columnName), columnType)
+	slog.Debug("executing query", "table", tableName, "query", query)
+
+	start := time.Now()
+	_, err = db.ExecContext(ctx, query)
+	if err != nil {
+		return newError(StepAddColumn, tableName, columnName, err)
+	}
+	if err = recordArtifact(ctx, Artifact{Kind: ArtifactColumn, Table: tableName, Name: columnName, definition: tableSQL}, db); err != nil {
+		return err
+	}
+	recordColumn(db, tableName, columnName, columnType)
+	recordStep(db, tableName, StepAddColumn, columnName, 0, start)
+	return nil
+}
+
+func executeQuery(ctx context.Context, tableName string, query string, db dbtx) error {
+	query = fmt.Sprintf("%s;", query)
+	slog.Debug("executing query", "table", tableName, "query", query)
+
+	start := time.Now()
+	result, err := db.ExecContext(ctx, query)
+	if err != nil {
+		return newError(StepExecuteStatement, tableName, "", fmt.Errorf("error executing query '%s': %w", query, err))
+	}
+	recordStep(db, tableName, StepExecuteStatement, "", rowsAffected(result), start)
+	return nil
+}
+
+// analyze updates the statistics of the whole GeoPackage, the time spent is reported for the given table. The
+// statistics tables created are recorded as artifacts.
+func analyze(ctx context.Context, tableName string, db dbtx) error {
+	start := time.Now()
+	before, err := getStatisticsTables(ctx, db)
+	if err != nil {
+		return err
+	}
+	if _, err = db.ExecContext(ctx, "ANALYZE"); err != nil {
+		return newError(StepAnalyze, "", "", err)
+	}
+	after, err := getStatisticsTables(ctx, db)
+	if err != nil {
+		return err
+	}
+	if err = recordNewTables(ctx, after, before, db); err != nil {
+		return err
+	}
+	recordStep(db, tableName, StepAnalyze, "", 0, start)
+	return nil
+}
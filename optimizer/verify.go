@@ -0,0 +1,477 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Verification lists the checks performed on an optimized GeoPackage per table
+type Verification struct {
+	ServiceType string              `json:"serviceType"`
+	Passed      bool                `json:"passed"`
+	Tables      []TableVerification `json:"tables"`
+	// LastRun is the last optimization run recorded for the service type, nil when none is recorded
+	LastRun *Run `json:"lastRun,omitempty"`
+	// ConfigChanged reports whether the last run used a different config than the one verified against
+	ConfigChanged bool `json:"configChanged"`
+}
+
+// TableVerification lists the checks performed on a table
+type TableVerification struct {
+	Table  string  `json:"table"`
+	Checks []Check `json:"checks"`
+}
+
+// Check is the outcome of a single check, failed checks have a message explaining why
+type Check struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// String renders the verification as human-readable text
+func (v *Verification) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Verification of %s optimizations:\n", strings.ToUpper(v.ServiceType))
+	if v.LastRun != nil {
+		fmt.Fprintf(&sb, "\nlast optimized by version %s", v.LastRun.Version)
+		if v.LastRun.Commit != "" {
+			fmt.Fprintf(&sb, " (%s)", v.LastRun.Commit)
+		}
+		fmt.Fprintf(&sb, " at %s", v.LastRun.Finished.Format(time.RFC3339))
+		if v.ConfigChanged {
+			sb.WriteString(", with a different config")
+		}
+		sb.WriteString("\n")
+	} else {
+		sb.WriteString("\nno optimization run recorded\n")
+	}
+	for _, table := range v.Tables {
+		fmt.Fprintf(&sb, "\ntable '%s':\n", table.Table)
+		for _, check := range table.Checks {
+			status := "OK  "
+			if !check.Passed {
+				status = "FAIL"
+			}
+			fmt.Fprintf(&sb, "  %s %s", status, check.Name)
+			if check.Message != "" {
+				fmt.Fprintf(&sb, ": %s", check.Message)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	if v.Passed {
+		sb.WriteString("\nall checks passed\n")
+	} else {
+		sb.WriteString("\nsome checks failed\n")
+	}
+	return sb.String()
+}
+
+// verifier collects the checks of a table
+type verifier struct {
+	ctx   context.Context
+	db    dbtx
+	table TableVerification
+}
+
+func (v *verifier) check(name string, problem string) {
+	v.table.Checks = append(v.table.Checks, Check{Name: name, Passed: problem == "", Message: problem})
+}
+
+// VerifyOWSFile opens the GeoPackage at the given path and verifies the OWS optimizations, without changing the GeoPackage
+func VerifyOWSFile(ctx context.Context, geopackage string, config *OwsConfig) (*Verification, error) {
+	db, err := openExisting(geopackage)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return VerifyOWS(ctx, db, config)
+}
+
+// VerifyOAFFile opens the GeoPackage at the given path and verifies the OAF optimizations, without changing the GeoPackage
+func VerifyOAFFile(ctx context.Context, geopackage string, config *OafConfig) (*Verification, error) {
+	db, err := openExisting(geopackage)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return VerifyOAF(ctx, db, config)
+}
+
+// openExisting opens the GeoPackage at the given path, which unlike OpenDB fails when it doesn't exist
+func openExisting(geopackage string) (*sql.DB, error) {
+	if _, err := os.Stat(geopackage); err != nil {
+		return nil, newError(StepOpen, "", "", err)
+	}
+	return OpenDB(geopackage)
+}
+
+// VerifyOWS checks that the OWS optimizations were applied to the given GeoPackage: every features and attributes
+// table has valid and unique puuids with matching fuuids and unique indexes on both, and the configured indices
+// exist. An error is only returned when the checks couldn't be performed.
+func VerifyOWS(ctx context.Context, db *sql.DB, config *OwsConfig) (*Verification, error) {
+	tables, err := getTables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	verifications := make(map[string]*verifier)
+	var order []string
+	verifierFor := func(table string) *verifier {
+		if _, ok := verifications[table]; !ok {
+			verifications[table] = &verifier{ctx: ctx, db: db, table: TableVerification{Table: table}}
+			order = append(order, table)
+		}
+		return verifications[table]
+	}
+
+	for _, table := range tables {
+		if table.DataType != dataTypeFeatures && table.DataType != dataTypeAttributes {
+			continue
+		}
+		v := verifierFor(table.Name)
+		if err = v.verifyPUUIDs(); err != nil {
+			return nil, err
+		}
+		for _, column := range []string{"puuid", "fuuid"} {
+			if err = v.verifyIndex(fmt.Sprintf("%s_%s_index", table.Name, column), []string{column}, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if config != nil {
+		for _, index := range config.Indices {
+			if err = verifierFor(index.Table).verifyIndex(index.Name, index.Columns, index.Unique); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return newVerification(ctx, db, "ows", config, order, verifications)
+}
+
+// VerifyOAF checks that the OAF optimizations were applied to the given GeoPackage: the envelope columns agree with
+// the geometries, the external_fids are filled, the spatial, temporal and external_fid indexes exist with the expected
+// columns and ANALYZE statistics are present. Without config only the default optimizations are verified.
+// An error is only returned when the checks couldn't be performed.
+func VerifyOAF(ctx context.Context, db *sql.DB, config *OafConfig) (*Verification, error) {
+	tables, err := getTables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		if err = config.setDefaults(); err != nil {
+			return nil, err
+		}
+		if err = config.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	verifications := make(map[string]*verifier)
+	var order []string
+	for _, table := range tables {
+		if table.DataType != dataTypeFeatures && table.DataType != dataTypeAttributes {
+			continue
+		}
+		var layerCfg Layer
+		if config != nil {
+			var ok bool
+			if layerCfg, ok = config.Layers[table.Name]; !ok {
+				continue
+			}
+		} else if table.DataType != dataTypeFeatures {
+			continue
+		}
+
+		v := &verifier{ctx: ctx, db: db, table: TableVerification{Table: table.Name}}
+		verifications[table.Name] = v
+		order = append(order, table.Name)
+		if err = v.verifyOAFLayer(table, layerCfg); err != nil {
+			return nil, err
+		}
+	}
+	return newVerification(ctx, db, "oaf", config, order, verifications)
+}
+
+// newVerification combines the checks of the tables, together with the last run recorded for the service type
+func newVerification(ctx context.Context, db dbtx, serviceType string, config any, order []string, verifications map[string]*verifier) (*Verification, error) {
+	result := &Verification{ServiceType: serviceType, Passed: true, Tables: []TableVerification{}}
+	for _, table := range order {
+		for _, check := range verifications[table].table.Checks {
+			result.Passed = result.Passed && check.Passed
+		}
+		result.Tables = append(result.Tables, verifications[table].table)
+	}
+	lastRun, err := getLastRun(ctx, serviceType, db)
+	if err != nil {
+		return nil, err
+	}
+	if lastRun == nil {
+		return result, nil
+	}
+	hash, err := configHash(config)
+	if err != nil {
+		return nil, err
+	}
+	result.LastRun, result.ConfigChanged = lastRun, hash != lastRun.ConfigHash
+	return result, nil
+}
+
+func (v *verifier) verifyOAFLayer(table gpkgTable, layerCfg Layer) error {
+	fidColumn, geomColumn, err := resolveColumns(v.ctx, table, layerCfg.FidColumn, layerCfg.GeomColumn, v.db)
+	if err != nil {
+		var optimizerErr *Error
+		if errors.As(err, &optimizerErr) && optimizerErr.Step == StepResolveColumns {
+			v.check("columns", optimizerErr.Err.Error())
+			return nil
+		}
+		return err
+	}
+
+	if len(layerCfg.ExternalFidColumns) > 0 {
+		if err = v.verifyExternalFids(); err != nil {
+			return err
+		}
+		indexName := fmt.Sprintf("%s_external_fid_idx", table.Name)
+		if err = v.verifyIndex(indexName, []string{"external_fid"}, uniqueExternalFid(layerCfg)); err != nil {
+			return err
+		}
+	}
+	if len(layerCfg.TemporalColumns) > 0 {
+		if err = v.verifyIndex(fmt.Sprintf("%s_temporal_idx", table.Name), layerCfg.TemporalColumns, false); err != nil {
+			return err
+		}
+	}
+	if table.DataType == dataTypeFeatures {
+		if err = v.verifyEnvelopes(geomColumn); err != nil {
+			return err
+		}
+		spatialColumns := append([]string{fidColumn, "minx", "maxx", "miny", "maxy"}, layerCfg.TemporalColumns...)
+		if err = v.verifyIndex(fmt.Sprintf("%s_spatial_idx", table.Name), spatialColumns, false); err != nil {
+			return err
+		}
+	}
+	return v.verifyStatistics()
+}
+
+// verifyPUUIDs checks that every row has a valid, unique puuid and a fuuid consisting of the table name and puuid
+func (v *verifier) verifyPUUIDs() error {
+	tableName := v.table.Table
+	for _, column := range []string{"puuid", "fuuid"} {
+		exists, err := columnExists(v.ctx, tableName, column, v.db)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			v.check(column, "column is missing")
+			return nil
+		}
+	}
+
+	rows, err := v.db.QueryContext(v.ctx, fmt.Sprintf("SELECT puuid, fuuid FROM %s", quoteIdentifier(tableName)))
+	if err != nil {
+		return newError(StepVerify, tableName, "puuid", err)
+	}
+	defer rows.Close()
+	var missing, invalid, mismatched int
+	for rows.Next() {
+		var puuid, fuuid sql.NullString
+		if err = rows.Scan(&puuid, &fuuid); err != nil {
+			return newError(StepVerify, tableName, "puuid", err)
+		}
+		if !puuid.Valid {
+			missing++
+			continue
+		}
+		if _, err = uuid.Parse(puuid.String); err != nil {
+			invalid++
+		}
+		if fuuid.String != fmt.Sprintf("%s.%s", tableName, puuid.String) {
+			mismatched++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return newError(StepVerify, tableName, "puuid", err)
+	}
+
+	duplicates, err := v.countDuplicates("puuid")
+	if err != nil {
+		return err
+	}
+	var problems []string
+	if missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d rows without puuid", missing))
+	}
+	if invalid > 0 {
+		problems = append(problems, fmt.Sprintf("%d invalid UUIDs", invalid))
+	}
+	if duplicates > 0 {
+		problems = append(problems, fmt.Sprintf("%d puuids shared by multiple rows", duplicates))
+	}
+	v.check("puuid", strings.Join(problems, ", "))
+	if mismatched > 0 {
+		v.check("fuuid", fmt.Sprintf("%d fuuids don't match '%s.<puuid>'", mismatched, tableName))
+	} else {
+		v.check("fuuid", "")
+	}
+	return nil
+}
+
+// verifyExternalFids checks that every row has a valid external_fid. Duplicates are allowed depending on the
+// external-fid-duplicates policy, so these are only checked by the UNIQUE index.
+func (v *verifier) verifyExternalFids() error {
+	tableName := v.table.Table
+	exists, err := columnExists(v.ctx, tableName, "external_fid", v.db)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		v.check("external_fid", "column is missing")
+		return nil
+	}
+	rows, err := v.db.QueryContext(v.ctx, fmt.Sprintf("SELECT external_fid FROM %s", quoteIdentifier(tableName)))
+	if err != nil {
+		return newError(StepVerify, tableName, "external_fid", err)
+	}
+	defer rows.Close()
+	var missing, invalid int
+	for rows.Next() {
+		var externalFid sql.NullString
+		if err = rows.Scan(&externalFid); err != nil {
+			return newError(StepVerify, tableName, "external_fid", err)
+		}
+		if !externalFid.Valid {
+			missing++
+		} else if _, err = uuid.Parse(externalFid.String); err != nil {
+			invalid++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return newError(StepVerify, tableName, "external_fid", err)
+	}
+	var problems []string
+	if missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d rows without external_fid", missing))
+	}
+	if invalid > 0 {
+		problems = append(problems, fmt.Sprintf("%d invalid UUIDs", invalid))
+	}
+	v.check("external_fid", strings.Join(problems, ", "))
+	return nil
+}
+
+// verifyEnvelopes checks that the envelope columns agree with the envelope of the geometry
+func (v *verifier) verifyEnvelopes(geomColumn string) error {
+	tableName := v.table.Table
+	for _, e := range envelopeColumns {
+		exists, err := columnExists(v.ctx, tableName, e.column, v.db)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			v.check("envelope", fmt.Sprintf("column '%s' is missing", e.column))
+			return nil
+		}
+	}
+
+	rows, err := v.db.QueryContext(v.ctx, fmt.Sprintf("SELECT %s, minx, maxx, miny, maxy FROM %s", quoteIdentifier(geomColumn), quoteIdentifier(tableName)))
+	if err != nil {
+		return newError(StepVerify, tableName, geomColumn, err)
+	}
+	defer rows.Close()
+	var mismatched, invalid int
+	for rows.Next() {
+		var geom any
+		var bounds [4]sql.NullFloat64
+		if err = rows.Scan(&geom, &bounds[0], &bounds[1], &bounds[2], &bounds[3]); err != nil {
+			return newError(StepVerify, tableName, geomColumn, err)
+		}
+		env, ok, err := geometryEnvelope(geom)
+		if err != nil {
+			invalid++
+			continue
+		}
+		expected := [4]float64{env.MinX, env.MaxX, env.MinY, env.MaxY}
+		for i, bound := range bounds {
+			if bound.Valid != ok || (ok && !closeTo(bound.Float64, expected[i])) {
+				mismatched++
+				break
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return newError(StepVerify, tableName, geomColumn, err)
+	}
+	var problems []string
+	if mismatched > 0 {
+		problems = append(problems, fmt.Sprintf("%d rows with an envelope that doesn't match the geometry", mismatched))
+	}
+	if invalid > 0 {
+		problems = append(problems, fmt.Sprintf("%d rows with an invalid geometry", invalid))
+	}
+	v.check("envelope", strings.Join(problems, ", "))
+	return nil
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// verifyIndex checks that the index exists on the given columns (in order) with the given uniqueness
+func (v *verifier) verifyIndex(indexName string, columnNames []string, unique bool) error {
+	index, err := getIndex(v.ctx, indexName, v.db)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("index %s", indexName)
+	switch {
+	case index == nil:
+		v.check(name, "index is missing")
+	case !index.matches(v.table.Table, columnNames, unique):
+		v.check(name, fmt.Sprintf("expected %s on %v (unique: %t), found %s on %v (unique: %t)",
+			v.table.Table, columnNames, unique, index.Table, index.Columns, index.Unique))
+	default:
+		v.check(name, "")
+	}
+	return nil
+}
+
+// verifyStatistics checks that ANALYZE statistics are present for the table
+func (v *verifier) verifyStatistics() error {
+	exists, err := tableExists(v.ctx, "sqlite_stat1", v.db)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = v.db.QueryRowContext(v.ctx, "SELECT EXISTS(SELECT 1 FROM sqlite_stat1 WHERE lower(tbl) = lower(?))", v.table.Table).Scan(&exists)
+		if err != nil {
+			return newError(StepVerify, v.table.Table, "", err)
+		}
+	}
+	if exists {
+		v.check("statistics", "")
+	} else {
+		v.check("statistics", "no ANALYZE statistics")
+	}
+	return nil
+}
+
+// countDuplicates returns the number of non-null values of the column shared by multiple rows
+func (v *verifier) countDuplicates(column string) (int, error) {
+	var duplicates int
+	err := v.db.QueryRowContext(v.ctx, fmt.Sprintf(
+		"SELECT count(*) FROM (SELECT 1 FROM %s WHERE %s IS NOT NULL GROUP BY %[2]s HAVING count(*) > 1)",
+		quoteIdentifier(v.table.Table), quoteIdentifier(column))).Scan(&duplicates)
+	if err != nil {
+		return 0, newError(StepVerify, v.table.Table, column, err)
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,126 @@
+package optimizer
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyOAF(t *testing.T) {
+	config, err := ParseOafConfig([]byte(`{"layers":{"pand":{"external-fid-columns":["identificatie"],"temporal-columns":["bouwjaar"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	if err = OptimizeOAFFile(context.Background(), "../geopackage/original_oaf.gpkg", config, WithOutput(outputGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+
+	verification, err := VerifyOAFFile(context.Background(), outputGeopackage, config)
+	if err != nil {
+		t.Fatalf("error verifying GeoPackage: %s", err)
+	}
+	if !verification.Passed {
+		t.Fatalf("expected verification to pass:\n%s", verification)
+	}
+	if verification.LastRun == nil || verification.LastRun.ServiceType != "oaf" || verification.ConfigChanged {
+		t.Fatalf("expected the last run with the same config, got:\n%s", verification)
+	}
+	otherConfig, err := ParseOafConfig([]byte(`{"layers":{"pand":{"external-fid-columns":["identificatie"]}}}`))
+	if err != nil {
+		t.Fatalf("error parsing config: %s", err)
+	}
+	verification, err = VerifyOAFFile(context.Background(), outputGeopackage, otherConfig)
+	if err != nil {
+		t.Fatalf("error verifying GeoPackage: %s", err)
+	}
+	if !verification.ConfigChanged {
+		t.Fatalf("expected the config to differ from the last run, got:\n%s", verification)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	if _, err = db.Exec("UPDATE pand SET minx = minx - 1 WHERE fid = 1; UPDATE pand SET external_fid = NULL WHERE fid = 2; DROP INDEX pand_temporal_idx; DROP INDEX pand_spatial_idx"); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	db.Close()
+
+	verification, err = VerifyOAFFile(context.Background(), outputGeopackage, config)
+	if err != nil {
+		t.Fatalf("error verifying GeoPackage: %s", err)
+	}
+	if verification.Passed {
+		t.Fatal("expected verification to fail")
+	}
+	failed := make(map[string]string)
+	for _, check := range verification.Tables[0].Checks {
+		if !check.Passed {
+			failed[check.Name] = check.Message
+		}
+	}
+	expected := map[string]string{
+		"envelope":                "1 rows with an envelope that doesn't match the geometry",
+		"external_fid":            "1 rows without external_fid",
+		"index pand_temporal_idx": "index is missing",
+		"index pand_spatial_idx":  "index is missing",
+	}
+	if len(failed) != len(expected) {
+		t.Fatalf("expected failed checks %v, got %v", expected, failed)
+	}
+	for name, message := range expected {
+		if failed[name] != message {
+			t.Fatalf("expected check '%s' to fail with '%s', got '%s'", name, message, failed[name])
+		}
+	}
+}
+
+func TestVerifyOWS(t *testing.T) {
+	outputGeopackage := filepath.Join(t.TempDir(), "geopackage.gpkg")
+	if err := OptimizeOWSFile(context.Background(), "../geopackage/original_ows.gpkg", nil, WithOutput(outputGeopackage)); err != nil {
+		t.Fatalf("error optimizing GeoPackage: %s", err)
+	}
+	verification, err := VerifyOWSFile(context.Background(), outputGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error verifying GeoPackage: %s", err)
+	}
+	if !verification.Passed {
+		t.Fatalf("expected verification to pass:\n%s", verification)
+	}
+
+	verification, err = VerifyOWSFile(context.Background(), "../geopackage/original_ows.gpkg", nil)
+	if err != nil {
+		t.Fatalf("error verifying GeoPackage: %s", err)
+	}
+	if verification.Passed || verification.Tables[0].Checks[0].Message != "column is missing" {
+		t.Fatalf("expected missing puuid column, got:\n%s", verification)
+	}
+
+	db, err := sql.Open("sqlite3_with_extensions", outputGeopackage)
+	if err != nil {
+		t.Fatalf("error opening GeoPackage: %s", err)
+	}
+	if _, err = db.Exec("UPDATE layer SET fuuid = 'layer.other' WHERE fid = 1"); err != nil {
+		t.Fatalf("error executing query: %s", err)
+	}
+	db.Close()
+	verification, err = VerifyOWSFile(context.Background(), outputGeopackage, nil)
+	if err != nil {
+		t.Fatalf("error verifying GeoPackage: %s", err)
+	}
+	var fuuidCheck *Check
+	for i, check := range verification.Tables[0].Checks {
+		if check.Name == "fuuid" {
+			fuuidCheck = &verification.Tables[0].Checks[i]
+		}
+	}
+	if verification.Passed || fuuidCheck == nil || fuuidCheck.Message != "1 fuuids don't match 'layer.<puuid>'" {
+		t.Fatalf("expected a mismatched fuuid, got:\n%s", verification)
+	}
+
+	if _, err = VerifyOWSFile(context.Background(), "../geopackage/missing.gpkg", nil); err == nil {
+		t.Fatal("expected an error for a missing GeoPackage")
+	}
+}
@@ -0,0 +1,58 @@
+package optimizer
+
+import (
+	"runtime/debug"
+)
+
+const modulePath = "github.com/PDOK/geopackage-optimizer-go"
+
+// Version and Commit identify the build of the optimizer, these are recorded in the provenance of every run. Set these
+// at build time with e.g. -ldflags "-X github.com/PDOK/geopackage-optimizer-go/optimizer.Version=v1.2.3", otherwise
+// they're taken from the build info Go embeds in the binary.
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// BuildVersion returns the version and git commit of the optimizer
+func BuildVersion() (version string, commit string) {
+	version, commit = Version, Commit
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return orDevel(version), commit
+	}
+	if info.Main.Path != modulePath {
+		// embedded as library, the pseudo-version of a dependency includes the commit
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath && version == "" {
+				version = dep.Version
+			}
+		}
+		return orDevel(version), commit
+	}
+	if version == "" {
+		version = info.Main.Version
+	}
+	if commit == "" {
+		var modified bool
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				commit = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if commit != "" && modified {
+			commit += "-dirty"
+		}
+	}
+	return orDevel(version), commit
+}
+
+func orDevel(version string) string {
+	if version == "" {
+		return "(devel)"
+	}
+	return version
+}
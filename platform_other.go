@@ -1,8 +0,0 @@
-// +build !windows
-
-package main
-
-// preloadDependencies is a no-op on non-Windows platforms
-func preloadDependencies() {
-	// No special handling needed for non-Windows platforms
-}